@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/wcharczuk/go-chart/v2"
+	"github.com/wcharczuk/go-chart/v2/drawing"
+)
+
+// renderChart draws battery SOC and grid presence (100 = grid, 0 = no grid)
+// over time as a PNG.
+func renderChart(l Lang, title string, samples []Sample) ([]byte, error) {
+	if len(samples) < 2 {
+		return nil, fmt.Errorf("not enough data: %d samples", len(samples))
+	}
+
+	times := make([]time.Time, len(samples))
+	soc := make([]float64, len(samples))
+	grid := make([]float64, len(samples))
+	for i, smp := range samples {
+		times[i] = smp.Time
+		soc[i] = smp.BatterySOC
+		if smp.HasGrid {
+			grid[i] = 100
+		}
+	}
+
+	graph := chart.Chart{
+		Title:  title,
+		Width:  1024,
+		Height: 512,
+		Background: chart.Style{
+			Padding: chart.Box{Top: 50, Left: 20, Right: 20, Bottom: 20},
+		},
+		XAxis: chart.XAxis{
+			ValueFormatter: chart.TimeValueFormatterWithFormat("15:04"),
+		},
+		YAxis: chart.YAxis{
+			Range:          &chart.ContinuousRange{Min: 0, Max: 100},
+			ValueFormatter: chart.IntValueFormatter,
+		},
+		Series: []chart.Series{
+			chart.TimeSeries{
+				Name: l.tr("chart.grid"),
+				Style: chart.Style{
+					StrokeColor: drawing.ColorFromHex("f5a623"),
+					FillColor:   drawing.ColorFromHex("f5a623").WithAlpha(48),
+				},
+				XValues: times,
+				YValues: grid,
+			},
+			chart.TimeSeries{
+				Name: l.tr("chart.battery"),
+				Style: chart.Style{
+					StrokeColor: drawing.ColorFromHex("2e7d32"),
+					StrokeWidth: 2,
+				},
+				XValues: times,
+				YValues: soc,
+			},
+		},
+	}
+	graph.Elements = []chart.Renderable{chart.Legend(&graph)}
+
+	var buf bytes.Buffer
+	if err := graph.Render(chart.PNG, &buf); err != nil {
+		return nil, fmt.Errorf("render chart: %w", err)
+	}
+	return buf.Bytes(), nil
+}
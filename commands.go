@@ -0,0 +1,932 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"html"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// command is a Telegram bot command. The registry below drives both
+// dispatch and the /help text.
+type command struct {
+	name        string
+	description string // message key of the /help line
+	handler     func(a *App, ctx context.Context, chatID int64, args string)
+}
+
+var commands []command
+
+func init() {
+	// Assigned in init because /help reads the registry it belongs to
+	commands = []command{
+		{"/status", "help.status", (*App).handleStatusCommand},
+		{"/battery", "help.battery", (*App).handleBatteryCommand},
+		{"/history", "help.history", (*App).handleHistoryCommand},
+		{"/stats", "help.stats", (*App).handleStatsCommand},
+		{"/chart", "help.chart", (*App).handleChartCommand},
+		{"/export", "help.export", (*App).handleExportCommand},
+		{"/next", "help.next", (*App).handleNextCommand},
+		{"/forecast", "help.forecast", (*App).handleForecastCommand},
+		{"/raw", "help.raw", (*App).handleRawCommand},
+		{"/devices", "help.devices", (*App).handleDevicesCommand},
+		{"/broadcast", "help.broadcast", (*App).handleBroadcastCommand},
+		{"/subscribe", "help.subscribe", (*App).handleSubscribeCommand},
+		{"/unsubscribe", "help.unsubscribe", (*App).handleUnsubscribeCommand},
+		{"/mute", "help.mute", (*App).handleMuteCommand},
+		{"/unmute", "help.unmute", (*App).handleUnmuteCommand},
+		{"/settings", "help.settings", (*App).handleSettingsCommand},
+		{"/uptime", "help.uptime", (*App).handleUptimeCommand},
+		{"/help", "help.help", (*App).handleHelpCommand},
+		{"/start", "help.start", (*App).handleStartCommand},
+	}
+}
+
+// commandTimeout bounds the API calls made while handling one update.
+const commandTimeout = 2 * time.Minute
+
+func (a *App) handleUpdate(ctx context.Context, update Update) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	if cq := update.CallbackQuery; cq != nil {
+		a.handleCallbackQuery(ctx, cq)
+		return
+	}
+
+	if update.Message == nil {
+		return
+	}
+
+	chatID := update.Message.Chat.ID
+	name, args := parseCommand(update.Message.Text, a.bot.Username())
+
+	if !a.bot.IsAllowedUser(chatID) {
+		if name == "/subscribe" {
+			if a.subscribeLimit.allow(chatID, time.Now()) {
+				a.requestSubscription(update.Message)
+			}
+			return
+		}
+		slog.Warn("[telegram] Unauthorized user", "chat", chatID)
+		// Tell the chat its ID, but in groups only when addressed
+		if (chatID > 0 || name != "") && a.onboarding.allow(chatID, time.Now()) {
+			a.reply(chatID, a.bot.LangFor(chatID).tr("onboarding.unknown", chatID))
+		}
+		return
+	}
+	a.bot.ClearBlocked(chatID)
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			cmd.handler(a, ctx, chatID, args)
+			return
+		}
+	}
+}
+
+// onboardingInterval is how often an unknown chat is told its chat ID.
+const onboardingInterval = time.Hour
+
+// subscribeInterval is how often an unknown chat's /subscribe is handled.
+// It is limited apart from the chat ID reply, which invites /subscribe.
+const subscribeInterval = 10 * time.Minute
+
+// replyLimiter lets one reply per chat through every interval, so chats
+// can't make the bot answer each of their messages.
+type replyLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[int64]time.Time
+}
+
+func newReplyLimiter(interval time.Duration) *replyLimiter {
+	return &replyLimiter{interval: interval, last: make(map[int64]time.Time)}
+}
+
+// allow reports whether chatID may get a reply at now, and if so counts it.
+func (r *replyLimiter) allow(chatID int64, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t, ok := r.last[chatID]; ok && now.Sub(t) < r.interval {
+		return false
+	}
+	// Forget chats whose interval is over so the map stays small
+	maps.DeleteFunc(r.last, func(_ int64, t time.Time) bool { return now.Sub(t) >= r.interval })
+	r.last[chatID] = now
+	return true
+}
+
+// parseCommand splits a message into the command and its arguments. In
+// groups Telegram appends the bot's username, as in /chart@SvitloBot 24;
+// the suffix is dropped if it names botUsername (any bot when that is
+// unknown). name is "" when text is not a command for this bot.
+func parseCommand(text, botUsername string) (name, args string) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return "", ""
+	}
+	name, args = text, ""
+	if i := strings.IndexFunc(text, unicode.IsSpace); i >= 0 {
+		name, args = text[:i], strings.TrimSpace(text[i:])
+	}
+	name, target, addressed := strings.Cut(name, "@")
+	if addressed && botUsername != "" && !strings.EqualFold(target, botUsername) {
+		return "", ""
+	}
+	return strings.ToLower(name), args
+}
+
+func (a *App) reply(chatID int64, text string) {
+	if err := a.bot.SendMessage(chatID, text); err != nil {
+		slog.Error("[telegram] Failed to send reply", "chat", chatID, "err", err)
+	}
+}
+
+func (a *App) handleStartCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	a.reply(chatID, l.tr("start"))
+}
+
+func (a *App) handleHelpCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	var b strings.Builder
+	b.WriteString(l.tr("help.title"))
+	for _, cmd := range commands {
+		fmt.Fprintf(&b, "%s — %s\n", cmd.name, html.EscapeString(l.tr(cmd.description)))
+	}
+	a.reply(chatID, b.String())
+}
+
+func (a *App) handleStatusCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	msg := a.buildStatusMessage(ctx, l, a.cfg.Stations) + a.muteNote(l, chatID)
+	if err := a.bot.SendStatus(chatID, msg, refreshKeyboard(l, a.cfg.Stations)); err != nil {
+		slog.Error("[telegram] Failed to send status", "chat", chatID, "err", err)
+	}
+}
+
+// buildStatusMessage renders the status of the given stations as one message.
+func (a *App) buildStatusMessage(ctx context.Context, l Lang, stations []Station) string {
+	var parts []string
+	for _, st := range stations {
+		status, err := a.power.GetPowerStatus(ctx, st.ID, st.DeviceSN)
+		if err != nil {
+			slog.Error("[telegram] Failed to get status", "station", st.name(), "err", err)
+			parts = append(parts, withStationLabel(st, l.tr("error.status")))
+			continue
+		}
+		parts = append(parts, withStationLabel(st, formatStatusMessage(l, status, shutdownLine(ctx, l, a.dtek))))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func (a *App) handleBatteryCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	var parts []string
+	for _, st := range a.cfg.Stations {
+		status, err := a.power.GetPowerStatus(ctx, st.ID, st.DeviceSN)
+		if err != nil {
+			slog.Error("[telegram] Failed to get status for /battery", "station", st.name(), "err", err)
+			parts = append(parts, withStationLabel(st, l.tr("error.status")))
+			continue
+		}
+		parts = append(parts, withStationLabel(st, formatBatteryMessage(l, status, a.cfg.DeyeBatteryCapacityWh)))
+	}
+
+	a.reply(chatID, strings.Join(parts, "\n\n"))
+}
+
+func (a *App) handleHistoryCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	now := time.Now()
+	from := now.Add(-24 * time.Hour)
+
+	var parts []string
+	for _, st := range a.cfg.Stations {
+		events, err := a.store.GridEventsSince(st, from)
+		if err != nil {
+			slog.Error("[telegram] Failed to load history", "station", st.name(), "err", err)
+			parts = append(parts, withStationLabel(st, l.tr("error.history")))
+			continue
+		}
+		parts = append(parts, withStationLabel(st, formatHistoryMessage(l, summarizeOutages(events, from, now))))
+	}
+
+	a.reply(chatID, strings.Join(parts, "\n\n"))
+}
+
+// statsDays is the window of /stats.
+const statsDays = 7
+
+// handleStatsCommand sums up the last statsDays of outages and when during
+// the day they usually happen.
+func (a *App) handleStatsCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	now := time.Now()
+	from := now.AddDate(0, 0, -statsDays)
+
+	var parts []string
+	for _, st := range a.cfg.Stations {
+		events, err := a.store.GridEventsSince(st, from)
+		if err != nil {
+			slog.Error("[telegram] Failed to load history for /stats", "station", st.name(), "err", err)
+			parts = append(parts, withStationLabel(st, l.tr("error.history")))
+			continue
+		}
+		sum := summarizeOutages(events, from, now)
+		byHour := outagesByHour(outagePeriods(events, from, now))
+		parts = append(parts, withStationLabel(st, formatStatsMessage(l, sum, byHour)))
+	}
+
+	a.reply(chatID, strings.Join(parts, "\n\n"))
+}
+
+const defaultChartHours = 12
+
+func (a *App) handleChartCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	hours := defaultChartHours
+	if args = strings.TrimSpace(args); args != "" {
+		h, err := strconv.Atoi(args)
+		if err != nil || h <= 0 || h > 24*30 {
+			a.reply(chatID, l.tr("chart.usage"))
+			return
+		}
+		hours = h
+	}
+
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	for _, st := range a.cfg.Stations {
+		samples, err := a.store.SamplesSince(st, since)
+		if err != nil {
+			slog.Error("[telegram] Failed to load samples", "station", st.name(), "err", err)
+			continue
+		}
+
+		caption := withStationLabel(st, l.tr("chart.caption", hours))
+		png, err := renderChart(l, l.tr("chart.title", st.name(), hours), samples)
+		if err != nil {
+			slog.Warn("[telegram] Failed to render chart", "station", st.name(), "err", err)
+			a.reply(chatID, caption+"\n"+l.tr("chart.no_data"))
+			continue
+		}
+		if err := a.bot.SendPhoto(chatID, bytes.NewReader(png), "chart.png", caption); err != nil {
+			slog.Error("[telegram] Failed to send chart", "chat", chatID, "err", err)
+		}
+	}
+}
+
+func (a *App) handleExportCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	args = strings.TrimSpace(args)
+	from, to, err := parseExportRange(args)
+	if err != nil {
+		a.reply(chatID, l.tr("export.usage"))
+		return
+	}
+
+	events, err := a.store.GridEventsBetween(from, to)
+	if err != nil {
+		slog.Error("[telegram] Failed to load events for /export", "err", err)
+		a.reply(chatID, l.tr("error.history"))
+		return
+	}
+	if len(events) == 0 {
+		a.reply(chatID, l.tr("export.empty"))
+		return
+	}
+
+	names := make(map[string]string, len(a.cfg.Stations))
+	for _, st := range a.cfg.Stations {
+		names[st.key()] = st.name()
+	}
+	data, err := gridEventsCSV(events, names)
+	if err != nil {
+		slog.Error("[telegram] Failed to build CSV", "err", err)
+		a.reply(chatID, l.tr("error.history"))
+		return
+	}
+
+	filename := "svitlo.csv"
+	if args != "" {
+		filename = "svitlo-" + args + ".csv"
+	}
+	if err := a.bot.SendDocument(chatID, bytes.NewReader(data), filename, l.tr("export.caption", len(events))); err != nil {
+		slog.Error("[telegram] Failed to send export", "chat", chatID, "err", err)
+	}
+}
+
+// parseExportRange turns a /export argument — a year (2024), month
+// (2024-01) or day (2024-01-15) — into the half-open range [from, to).
+// An empty argument selects everything.
+func parseExportRange(arg string) (from, to time.Time, err error) {
+	if arg == "" {
+		return time.Time{}, time.Time{}, nil
+	}
+	for _, p := range []struct {
+		layout              string
+		years, months, days int
+	}{
+		{"2006", 1, 0, 0},
+		{"2006-01", 0, 1, 0},
+		{"2006-01-02", 0, 0, 1},
+	} {
+		if from, err = time.ParseInLocation(p.layout, arg, time.Local); err == nil {
+			return from, from.AddDate(p.years, p.months, p.days), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q", arg)
+}
+
+// gridEventsCSV writes grid transitions as CSV. names maps Station.key() to
+// the station name; unknown keys, e.g. of removed stations, are kept as is.
+func gridEventsCSV(events []GridEvent, names map[string]string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"time", "station", "state", "battery_soc"})
+	for _, e := range events {
+		station, ok := names[e.Station]
+		if !ok {
+			station = e.Station
+		}
+		state := "off"
+		if e.HasGrid {
+			state = "on"
+		}
+		w.Write([]string{
+			e.Time.Format(time.RFC3339),
+			station,
+			state,
+			strconv.FormatFloat(e.BatterySOC, 'f', -1, 64),
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func (a *App) handleForecastCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	if a.dtek == nil {
+		a.reply(chatID, l.tr("dtek.disabled"))
+		return
+	}
+	shutdown, err := a.dtek.GetShutdown(ctx)
+	if err != nil {
+		slog.Error("[dtek] Failed to get shutdown", "err", err)
+		a.reply(chatID, l.tr("dtek.error"))
+		return
+	}
+	a.reply(chatID, formatForecastMessage(l, shutdown))
+}
+
+// handleNextCommand correlates the grid state with the DTEK schedule to say
+// when power should return, or when the next outage starts.
+func (a *App) handleNextCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	var shutdown *Shutdown
+	if a.dtek != nil {
+		var err error
+		if shutdown, err = a.dtek.GetShutdown(ctx); err != nil {
+			slog.Error("[dtek] Failed to get shutdown", "err", err)
+		}
+	}
+
+	var parts []string
+	for _, st := range a.cfg.Stations {
+		status, err := a.power.GetPowerStatus(ctx, st.ID, st.DeviceSN)
+		if err != nil {
+			slog.Error("[telegram] Failed to get status for /next", "station", st.name(), "err", err)
+			parts = append(parts, withStationLabel(st, l.tr("error.status")))
+			continue
+		}
+		parts = append(parts, withStationLabel(st, formatNextMessage(l, status.HasGrid, shutdown, time.Now())))
+	}
+	a.reply(chatID, strings.Join(parts, "\n\n"))
+}
+
+func (a *App) handleSubscribeCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	args = strings.ToLower(strings.TrimSpace(args))
+	if args == "" {
+		args = string(NotifyAll)
+	}
+
+	pref, ok := parseNotifyPref(args)
+	if !ok {
+		a.reply(chatID, l.tr("subscribe.usage"))
+		return
+	}
+	if err := a.settings.Update(chatID, func(cs *ChatSettings) { cs.Notify = pref }); err != nil {
+		slog.Error("[settings] Failed to save preferences", "chat", chatID, "err", err)
+		a.reply(chatID, l.tr("settings_failed"))
+		return
+	}
+	a.reply(chatID, l.tr("subscribe.done", l.tr("notify."+string(pref))))
+}
+
+// handleUnsubscribeCommand mutes users listed in TELEGRAM_USER_IDS (they
+// stay there) and removes approved subscribers altogether.
+func (a *App) handleUnsubscribeCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	listed := a.bot.IsListedUser(chatID)
+	err := a.settings.Update(chatID, func(cs *ChatSettings) {
+		if listed {
+			cs.Notify = NotifyNone
+		} else {
+			*cs = ChatSettings{}
+		}
+	})
+	if err != nil {
+		slog.Error("[settings] Failed to save preferences", "chat", chatID, "err", err)
+		a.reply(chatID, l.tr("settings_failed"))
+		return
+	}
+	if listed {
+		a.reply(chatID, l.tr("unsubscribe.muted"))
+		return
+	}
+	a.reply(chatID, l.tr("unsubscribe.left"))
+}
+
+// maxMute is the longest /mute accepted, so a forgotten mute doesn't
+// silence alerts indefinitely.
+const maxMute = 7 * 24 * time.Hour
+
+// handleMuteCommand silences automatic alerts for a while: "/mute 2h" for
+// this chat, "/mute 2h all" for every chat and sink (admins only).
+func (a *App) handleMuteCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	fields := strings.Fields(args)
+	if len(fields) == 0 || len(fields) > 2 || (len(fields) == 2 && fields[1] != "all") {
+		a.reply(chatID, l.tr("mute.usage"))
+		return
+	}
+	d, err := time.ParseDuration(fields[0])
+	if err != nil || d <= 0 || d > maxMute {
+		a.reply(chatID, l.tr("mute.usage"))
+		return
+	}
+	target, doneKey := chatID, "mute.done"
+	if len(fields) == 2 {
+		if !a.bot.IsAdmin(chatID) {
+			a.reply(chatID, l.tr("admin_only"))
+			return
+		}
+		target, doneKey = allChats, "mute.done_all"
+	}
+
+	until := time.Now().Add(d)
+	if err := a.settings.Update(target, func(cs *ChatSettings) { cs.MutedUntil = until }); err != nil {
+		slog.Error("[settings] Failed to save mute", "chat", chatID, "err", err)
+		a.reply(chatID, l.tr("settings_failed"))
+		return
+	}
+	slog.Info("[telegram] Alerts muted", "chat", chatID, "all", target == allChats, "until", until.Format("2006-01-02 15:04"))
+	a.reply(chatID, l.tr(doneKey, until.Format("15:04 02.01")))
+}
+
+// handleUnmuteCommand lifts /mute: "/unmute" for this chat, "/unmute all"
+// for the global one (admins only).
+func (a *App) handleUnmuteCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	target, doneKey := chatID, "unmute.done"
+	switch strings.TrimSpace(args) {
+	case "":
+	case "all":
+		if !a.bot.IsAdmin(chatID) {
+			a.reply(chatID, l.tr("admin_only"))
+			return
+		}
+		target, doneKey = allChats, "unmute.done_all"
+	default:
+		a.reply(chatID, l.tr("unmute.usage"))
+		return
+	}
+
+	if err := a.settings.Update(target, func(cs *ChatSettings) { cs.MutedUntil = time.Time{} }); err != nil {
+		slog.Error("[settings] Failed to save mute", "chat", chatID, "err", err)
+		a.reply(chatID, l.tr("settings_failed"))
+		return
+	}
+	// An admin's /mute all still holds
+	if until := a.bot.MutedUntil(chatID); !until.IsZero() {
+		a.reply(chatID, l.tr("mute.note", until.Format("15:04 02.01")))
+		return
+	}
+	a.reply(chatID, l.tr(doneKey))
+}
+
+// muteNote tells a chat asking for /status that its alerts are muted.
+func (a *App) muteNote(l Lang, chatID int64) string {
+	until := a.bot.MutedUntil(chatID)
+	if until.IsZero() {
+		return ""
+	}
+	return "\n\n" + l.tr("mute.note", until.Format("15:04 02.01"))
+}
+
+func (a *App) handleSettingsCommand(ctx context.Context, chatID int64, args string) {
+	text, markup := a.settingsMenu(a.settings.Get(chatID))
+	if _, err := a.bot.sendMessage(chatID, text, markup); err != nil {
+		slog.Error("[telegram] Failed to send settings", "chat", chatID, "err", err)
+	}
+}
+
+// settingsMenu renders a chat's settings and the buttons changing them, in
+// the chat's language. The current choices are ticked.
+func (a *App) settingsMenu(cs ChatSettings) (string, *InlineKeyboardMarkup) {
+	l := cs.Lang
+	tick := func(selected bool, label string) string {
+		if selected {
+			return "✅ " + label
+		}
+		return label
+	}
+	button := func(label, setting, value string) InlineKeyboardButton {
+		data := settingsCallbackAction + ":" + setting
+		if value != "" {
+			data += ":" + value
+		}
+		return InlineKeyboardButton{Text: label, CallbackData: data}
+	}
+
+	var b strings.Builder
+	b.WriteString(l.tr("settings.title"))
+	b.WriteString(l.tr("settings.notify", l.tr("notify."+string(cs.Notify))))
+	var rows [][]InlineKeyboardButton
+	for _, pair := range [][]NotifyPref{{NotifyAll, NotifyNone}, {NotifyPowerOn, NotifyPowerOff}} {
+		var row []InlineKeyboardButton
+		for _, pref := range pair {
+			label := tick(cs.Notify == pref, l.tr("settings.button_"+string(pref)))
+			row = append(row, button(label, "notify", string(pref)))
+		}
+		rows = append(rows, row)
+	}
+
+	if qh := a.cfg.QuietHours; qh != nil {
+		state, label := l.tr("settings.quiet_hold"), l.tr("settings.button_hold")
+		if cs.IgnoreQuietHours {
+			state, label = l.tr("settings.quiet_ignore"), l.tr("settings.button_ignore")
+		}
+		b.WriteString(l.tr("settings.quiet", qh.String(), state))
+		rows = append(rows, []InlineKeyboardButton{button(label, "quiet", "")})
+	}
+
+	b.WriteString(l.tr("settings.lang", l.tr("lang.name")))
+	var row []InlineKeyboardButton
+	for _, lang := range slices.Sorted(maps.Keys(bundles)) {
+		row = append(row, button(tick(lang == l, lang.tr("lang.name")), "lang", string(lang)))
+	}
+	rows = append(rows, row)
+
+	return strings.TrimSuffix(b.String(), "\n"), &InlineKeyboardMarkup{InlineKeyboard: rows}
+}
+
+func (a *App) handleUptimeCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	a.reply(chatID, formatUptimeMessage(l, a.health.Stats(), a.dtek, time.Now()))
+}
+
+// formatUptimeMessage reports process uptime and connection stats. dtek may
+// be nil when the integration is disabled.
+func formatUptimeMessage(l Lang, s HealthStats, dtek ShutdownProvider, now time.Time) string {
+	var b strings.Builder
+	b.WriteString(l.tr("uptime.title"))
+	b.WriteString(l.tr("uptime.started", s.Started.Format("15:04 02.01.2006"), formatDuration(l, now.Sub(s.Started))))
+	b.WriteString(l.tr("uptime.polls", s.Polls, s.Polls-s.Failures, s.Failures))
+	if s.LastError != "" {
+		b.WriteString(l.tr("uptime.last_error", s.LastErrorAt.Format("15:04 02.01"), html.EscapeString(s.LastError)))
+	}
+	switch {
+	case dtek == nil:
+		b.WriteString(l.tr("uptime.dtek_disabled"))
+	case dtek.CachedAt().IsZero():
+		b.WriteString(l.tr("uptime.dtek_none"))
+	default:
+		b.WriteString(l.tr("uptime.dtek_age", formatDuration(l, now.Sub(dtek.CachedAt()))))
+	}
+	return b.String()
+}
+
+// handleRawCommand dumps every data item the inverter reports, for
+// debugging detection and key names. Admins only.
+func (a *App) handleRawCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	if !a.bot.IsAdmin(chatID) {
+		a.reply(chatID, l.tr("admin_only"))
+		return
+	}
+
+	var parts []string
+	for _, st := range a.cfg.Stations {
+		resp, err := a.deye.GetDeviceLatest(ctx, []string{st.DeviceSN})
+		if err != nil {
+			slog.Error("[telegram] Failed to get device data for /raw", "station", st.name(), "err", err)
+			parts = append(parts, withStationLabel(st, l.tr("error.device")))
+			continue
+		}
+		for _, dev := range resp.DeviceList {
+			parts = append(parts, withStationLabel(st, formatRawDeviceData(l, dev)))
+		}
+	}
+	if len(parts) == 0 {
+		a.reply(chatID, l.tr("raw.empty"))
+		return
+	}
+	a.reply(chatID, strings.Join(parts, "\n\n"))
+}
+
+// handleDevicesCommand lists the devices on the Deye account, to find the
+// DEYE_STATION_ID and DEYE_DEVICE_SN of a new inverter. Admins only.
+func (a *App) handleDevicesCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	if !a.bot.IsAdmin(chatID) {
+		a.reply(chatID, l.tr("admin_only"))
+		return
+	}
+
+	resp, err := a.deye.GetDeviceList(ctx)
+	if err != nil {
+		slog.Error("[telegram] Failed to get device list for /devices", "err", err)
+		a.reply(chatID, l.tr("error.devices"))
+		return
+	}
+	if len(resp.Devices) == 0 {
+		a.reply(chatID, l.tr("devices.empty"))
+		return
+	}
+	a.reply(chatID, formatDeviceList(l, resp.Devices))
+}
+
+// handleBroadcastCommand relays an admin's announcement, which may use
+// Telegram HTML, to every chat that gets alerts.
+func (a *App) handleBroadcastCommand(ctx context.Context, chatID int64, args string) {
+	l := a.bot.LangFor(chatID)
+	if !a.bot.IsAdmin(chatID) {
+		a.reply(chatID, l.tr("admin_only"))
+		return
+	}
+	text := strings.TrimSpace(args)
+	if text == "" {
+		a.reply(chatID, l.tr("broadcast.usage"))
+		return
+	}
+
+	slog.Info("[telegram] Broadcasting announcement", "from", chatID)
+	a.bot.Broadcast(AlertInfo, func(l Lang) string { return l.tr("broadcast.title") + text })
+	a.reply(chatID, l.tr("broadcast.sent"))
+}
+
+// requestSubscription asks the admins to approve a chat that is not yet
+// allowed to use the bot.
+func (a *App) requestSubscription(msg *Message) {
+	chatID := msg.Chat.ID
+	l := a.bot.LangFor(chatID)
+	if !a.requests.add(chatID) {
+		slog.Info("[telegram] Subscription already requested", "chat", chatID)
+		a.reply(chatID, l.tr("request.pending"))
+		return
+	}
+	who := strconv.FormatInt(chatID, 10)
+	if u := msg.From; u != nil {
+		who = html.EscapeString(u.FirstName)
+		if u.Username != "" {
+			who += " @" + html.EscapeString(u.Username)
+		}
+		who += fmt.Sprintf(" (%d)", chatID)
+	}
+	slog.Info("[telegram] Subscription requested", "chat", chatID)
+
+	target := strconv.FormatInt(chatID, 10)
+	for _, adminID := range a.bot.AdminIDs() {
+		l := a.bot.LangFor(adminID)
+		markup := &InlineKeyboardMarkup{
+			InlineKeyboard: [][]InlineKeyboardButton{{
+				{Text: l.tr("request.approve"), CallbackData: approveCallbackAction + ":" + target},
+				{Text: l.tr("request.reject"), CallbackData: rejectCallbackAction + ":" + target},
+			}},
+		}
+		if _, err := a.bot.sendMessage(adminID, l.tr("request.admin", who), markup); err != nil {
+			slog.Error("[telegram] Failed to send subscription request", "chat", adminID, "err", err)
+		}
+	}
+	a.reply(chatID, l.tr("request.sent"))
+}
+
+// subscriptionRequests tracks chats whose /subscribe awaits an admin's
+// decision, so asking again doesn't notify the admins again.
+type subscriptionRequests struct {
+	mu      sync.Mutex
+	pending map[int64]bool
+}
+
+func newSubscriptionRequests() *subscriptionRequests {
+	return &subscriptionRequests{pending: make(map[int64]bool)}
+}
+
+// add marks chatID as pending and reports whether it was not already.
+func (r *subscriptionRequests) add(chatID int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending[chatID] {
+		return false
+	}
+	r.pending[chatID] = true
+	return true
+}
+
+// done lets chatID request again once its request is decided.
+func (r *subscriptionRequests) done(chatID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, chatID)
+}
+
+// --- Callback queries (inline buttons) ---
+
+// Callback data actions
+const (
+	refreshCallbackAction  = "refresh"  // status refresh button
+	approveCallbackAction  = "approve"  // subscription request approval
+	rejectCallbackAction   = "reject"   // subscription request rejection
+	settingsCallbackAction = "settings" // /settings menu: settings:<setting>[:<value>]
+)
+
+// refreshKeyboard is the refresh button attached to status messages.
+// Its callback data names the station, or "all" for multi-station messages.
+func refreshKeyboard(l Lang, stations []Station) *InlineKeyboardMarkup {
+	target := "all"
+	if len(stations) == 1 {
+		target = strconv.FormatInt(stations[0].ID, 10)
+	}
+	return &InlineKeyboardMarkup{
+		InlineKeyboard: [][]InlineKeyboardButton{{
+			{Text: l.tr("button.refresh"), CallbackData: refreshCallbackAction + ":" + target},
+		}},
+	}
+}
+
+func (a *App) handleCallbackQuery(ctx context.Context, cq *CallbackQuery) {
+	l := a.callbackLang(cq)
+	if !a.callbackAllowed(cq, a.bot.IsAllowedUser) {
+		slog.Warn("[telegram] Unauthorized callback", "user", cq.From.ID)
+		a.answerCallback(cq, l.tr("callback.denied"))
+		return
+	}
+
+	action, payload, _ := strings.Cut(cq.Data, ":")
+	switch action {
+	case refreshCallbackAction:
+		a.handleRefreshCallback(ctx, cq, payload)
+	case approveCallbackAction, rejectCallbackAction:
+		a.handleSubscriptionDecision(cq, payload, action == approveCallbackAction)
+	case settingsCallbackAction:
+		a.handleSettingsCallback(cq, payload)
+	default:
+		slog.Warn("[telegram] Unknown callback data", "data", cq.Data, "user", cq.From.ID)
+		a.answerCallback(cq, "")
+	}
+}
+
+// callbackAllowed checks the user who pressed the button, or the group the
+// message is in, so members of an allowed group can use its buttons.
+func (a *App) callbackAllowed(cq *CallbackQuery, allowed func(int64) bool) bool {
+	if allowed(cq.From.ID) {
+		return true
+	}
+	return cq.Message != nil && cq.Message.Chat.ID < 0 && allowed(cq.Message.Chat.ID)
+}
+
+// callbackLang is the language of the chat the pressed button is in.
+func (a *App) callbackLang(cq *CallbackQuery) Lang {
+	if cq.Message != nil {
+		return a.bot.LangFor(cq.Message.Chat.ID)
+	}
+	return a.bot.LangFor(cq.From.ID)
+}
+
+func (a *App) answerCallback(cq *CallbackQuery, text string) {
+	if err := a.bot.AnswerCallbackQuery(cq.ID, text); err != nil {
+		slog.Error("[telegram] Failed to answer callback", "err", err)
+	}
+}
+
+func (a *App) handleRefreshCallback(ctx context.Context, cq *CallbackQuery, target string) {
+	if cq.Message == nil {
+		a.answerCallback(cq, "")
+		return
+	}
+	l := a.callbackLang(cq)
+
+	stations := a.cfg.Stations
+	if target != "all" {
+		stations = nil
+		for _, st := range a.cfg.Stations {
+			if strconv.FormatInt(st.ID, 10) == target {
+				stations = append(stations, st)
+			}
+		}
+		if len(stations) == 0 {
+			a.answerCallback(cq, l.tr("callback.not_found"))
+			return
+		}
+	}
+
+	msg := a.buildStatusMessage(ctx, l, stations) + a.muteNote(l, cq.Message.Chat.ID)
+	if err := a.bot.EditMessageWithMarkup(cq.Message.Chat.ID, cq.Message.MessageID, msg, refreshKeyboard(l, stations)); err != nil {
+		slog.Error("[telegram] Failed to refresh status message", "err", err)
+		a.answerCallback(cq, l.tr("callback.failed"))
+		return
+	}
+	a.answerCallback(cq, l.tr("callback.refreshed"))
+}
+
+// handleSettingsCallback applies a /settings button to the chat the menu is
+// in and redraws the menu.
+func (a *App) handleSettingsCallback(cq *CallbackQuery, payload string) {
+	if cq.Message == nil {
+		a.answerCallback(cq, "")
+		return
+	}
+	chatID := cq.Message.Chat.ID
+
+	var apply func(cs *ChatSettings)
+	setting, value, _ := strings.Cut(payload, ":")
+	switch setting {
+	case "notify":
+		if pref, ok := parseNotifyPref(value); ok {
+			apply = func(cs *ChatSettings) { cs.Notify = pref }
+		}
+	case "quiet":
+		apply = func(cs *ChatSettings) { cs.IgnoreQuietHours = !cs.IgnoreQuietHours }
+	case "lang":
+		if lang, ok := parseLang(value); ok {
+			apply = func(cs *ChatSettings) { cs.Lang = lang }
+		}
+	}
+	if apply == nil {
+		slog.Warn("[telegram] Unknown settings callback", "data", cq.Data, "user", cq.From.ID)
+		a.answerCallback(cq, "")
+		return
+	}
+
+	if err := a.settings.Update(chatID, apply); err != nil {
+		slog.Error("[settings] Failed to save preferences", "chat", chatID, "err", err)
+		a.answerCallback(cq, a.callbackLang(cq).tr("callback.not_saved"))
+		return
+	}
+	cs := a.settings.Get(chatID)
+	text, markup := a.settingsMenu(cs)
+	if err := a.bot.EditMessageWithMarkup(chatID, cq.Message.MessageID, text, markup); err != nil {
+		slog.Error("[telegram] Failed to update settings menu", "err", err)
+	}
+	a.answerCallback(cq, cs.Lang.tr("settings.saved"))
+}
+
+func (a *App) handleSubscriptionDecision(cq *CallbackQuery, target string, approved bool) {
+	l := a.callbackLang(cq)
+	if !a.callbackAllowed(cq, a.bot.IsAdmin) {
+		a.answerCallback(cq, l.tr("callback.admin_only"))
+		return
+	}
+	chatID, err := strconv.ParseInt(target, 10, 64)
+	if err != nil {
+		a.answerCallback(cq, "")
+		return
+	}
+
+	outcome := l.tr("request.rejected")
+	if approved {
+		if err := a.settings.Update(chatID, func(cs *ChatSettings) { cs.Subscriber = true }); err != nil {
+			slog.Error("[settings] Failed to save subscriber", "chat", chatID, "err", err)
+			a.answerCallback(cq, l.tr("callback.not_saved"))
+			return
+		}
+		outcome = l.tr("request.approved")
+		a.reply(chatID, a.bot.LangFor(chatID).tr("request.welcome"))
+	} else {
+		a.reply(chatID, a.bot.LangFor(chatID).tr("request.declined"))
+	}
+	a.requests.done(chatID)
+	slog.Info("[telegram] Subscription request decided", "chat", chatID, "approved", approved, "by", cq.From.ID)
+
+	if cq.Message != nil {
+		text := html.EscapeString(cq.Message.Text) + "\n\n" + outcome
+		if err := a.bot.EditMessage(cq.Message.Chat.ID, cq.Message.MessageID, text); err != nil {
+			slog.Error("[telegram] Failed to update subscription request", "err", err)
+		}
+	}
+	a.answerCallback(cq, outcome)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		text, bot, name, args string
+	}{
+		{"/status", "SvitloBot", "/status", ""},
+		{"/chart 24", "SvitloBot", "/chart", "24"},
+		{"/history   7d ", "SvitloBot", "/history", "7d"},
+		{"/chart@SvitloBot 24", "SvitloBot", "/chart", "24"},
+		{"/Status@svitlobot", "SvitloBot", "/status", ""},
+		{"/status@OtherBot", "SvitloBot", "", ""},
+		{"/status@OtherBot", "", "/status", ""},
+		{"/broadcast\n<b>Увага</b>\nдругий рядок", "SvitloBot", "/broadcast", "<b>Увага</b>\nдругий рядок"},
+		{"hello /status", "SvitloBot", "", ""},
+		{"", "SvitloBot", "", ""},
+	}
+	for _, tt := range tests {
+		name, args := parseCommand(tt.text, tt.bot)
+		if name != tt.name || args != tt.args {
+			t.Errorf("parseCommand(%q, %q) = %q, %q; want %q, %q", tt.text, tt.bot, name, args, tt.name, tt.args)
+		}
+	}
+}
+
+func TestReplyLimiter(t *testing.T) {
+	r := newReplyLimiter(time.Hour)
+	now := time.Now()
+
+	if !r.allow(1, now) {
+		t.Fatal("first reply blocked")
+	}
+	if r.allow(1, now.Add(59*time.Minute)) {
+		t.Error("second reply within the interval allowed")
+	}
+	if !r.allow(2, now.Add(time.Minute)) {
+		t.Error("other chat blocked")
+	}
+	if !r.allow(1, now.Add(time.Hour)) {
+		t.Error("reply after the interval blocked")
+	}
+	if len(r.last) != 2 {
+		t.Errorf("limiter tracks %d chats, want 2", len(r.last))
+	}
+}
+
+func TestSubscriptionRequests(t *testing.T) {
+	r := newSubscriptionRequests()
+	if !r.add(1) {
+		t.Fatal("first request ignored")
+	}
+	if r.add(1) {
+		t.Error("repeated request accepted while pending")
+	}
+	if !r.add(2) {
+		t.Error("other chat's request ignored")
+	}
+	r.done(1)
+	if !r.add(1) {
+		t.Error("request after the decision ignored")
+	}
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -17,22 +24,160 @@ type Config struct {
 	DeyeEmail     string
 	DeyePassword  string
 
+	// DeyeTokenCache is the path where Deye tokens are persisted between
+	// restarts. Empty disables the cache.
+	DeyeTokenCache string
+
+	// DeyeTLS verifies the Deye endpoint against DEYE_CA_CERT and
+	// DEYE_CERT_PINS; nil uses the system defaults
+	DeyeTLS *tls.Config
+
+	// DeyeStaleAfter is how old the inverter's lastUpdateTime may be before
+	// its readings are no longer trusted for alerts; 0 disables the check
+	DeyeStaleAfter time.Duration
+
+	// DeyeReplayFile, when set, makes the poller play back recorded Deye
+	// responses from this file instead of calling the API
+	DeyeReplayFile string
+
+	// Home Assistant as the power source instead of Deye Cloud; HAURL
+	// empty disables it
+	HAURL      string
+	HAToken    string
+	HAEntities HAEntities
+
 	// Deye Device
 	DeyeStationID int64
 	DeyeDeviceSN  string
 
+	// Stations lists every inverter to monitor. When DEYE_STATIONS is not
+	// set it holds the single DEYE_STATION_ID/DEYE_DEVICE_SN pair (filled in
+	// by main after device discovery).
+	Stations []Station
+
+	// DeyeBatteryCapacityWh is the usable battery capacity, used for
+	// charge/runtime estimates. 0 means unknown.
+	DeyeBatteryCapacityWh float64
+
 	// Telegram
 	TelegramBotToken string
 	TelegramUserIDs  []int64
+	// AdminUserIDs are the TelegramUserIDs entries allowed to run admin
+	// commands and approve subscribers; all of them unless ADMIN_USER_IDS
+	// is set
+	AdminUserIDs []int64
+	// TelegramThreadID is the forum topic alerts are posted to in group chats
+	TelegramThreadID int64
+	// TelegramRateLimit caps outgoing Bot API requests per second
+	TelegramRateLimit float64
+	// WebhookURL is the public HTTPS URL Telegram posts updates to; empty
+	// uses long polling
+	WebhookURL string
+	// WebhookListenAddr is where the webhook server listens
+	WebhookListenAddr string
+
+	// ProxyURL routes requests to Deye, Telegram, DTEK, Discord and ntfy
+	// through this proxy instead of HTTP_PROXY/HTTPS_PROXY; nil when unset
+	ProxyURL *url.URL
+
+	// DiscordWebhookURL additionally sends alerts to a Discord channel; empty disables it
+	DiscordWebhookURL string
+
+	// ntfy topic for alerts; NtfyURL empty disables it
+	NtfyURL   string
+	NtfyTopic string
+	// NtfyToken is an optional access token for private servers
+	NtfyToken string
 
 	// Polling
 	PollIntervalSec int
+	// PollSchedule overrides PollIntervalSec during parts of the day; the
+	// first window containing the current time wins
+	PollSchedule []PollWindow
+	// PollJitterPercent randomizes each wait between polls by up to this
+	// percentage either way; 0 polls at exact intervals
+	PollJitterPercent int
+	// GridDebounceSec is how long a new grid state must persist before it is
+	// announced. 0 announces on the first poll that sees it.
+	GridDebounceSec int
+	// BroadcastDedupeSec is how long an alert identical to the previous one
+	// is dropped; 0 sends every alert
+	BroadcastDedupeSec int
+	// GridThresholds tune grid detection
+	GridThresholds GridThresholds
+	// BatteryLowSOC is the SOC (%) below which an off-grid battery is
+	// announced as running low; 0 disables the alert
+	BatteryLowSOC float64
+	// QuietHours suppresses automatic grid alerts; nil when not configured
+	QuietHours *ClockRange
+	// DailySummaryAt is the time of day (offset from midnight) of the daily
+	// energy report; nil disables it
+	DailySummaryAt *time.Duration
+	// MonthlyReportAt is the time of day the previous month's energy report
+	// is sent on the 1st; nil disables it
+	MonthlyReportAt *time.Duration
+
+	// DTEK shutdown schedule
+	DtekEnabled bool
+	// Provider selects the regional outage schedule source, see dtekSites
+	Provider   string
+	DtekCity   string
+	DtekStreet string
+	DtekHouse  string
+	// DtekFetchAttempts is how many times a DTEK scrape is tried before giving up
+	DtekFetchAttempts int
+	// DtekMaxStale is how long the last good DTEK schedule is shown when fetching fails
+	DtekMaxStale time.Duration
+	// DtekCacheTTL is how long a fetched DTEK schedule is reused
+	DtekCacheTTL time.Duration
+	// DtekBreakerFailures is how many failed DTEK fetches in a row stop
+	// scraping for DtekBreakerCooldown; 0 never stops
+	DtekBreakerFailures int
+	DtekBreakerCooldown time.Duration
+
+	// DBPath is the SQLite database with grid history
+	DBPath string
+
+	// SettingsPath is the JSON file with per-chat preferences
+	SettingsPath string
+
+	// Lang selects the language of bot messages; chats may override it
+	// in /settings
+	Lang Lang
+
+	// Location is the time zone of message timestamps, quiet hours and
+	// scheduled messages
+	Location *time.Location
+
+	// TemplatesDir holds custom status/poweron/poweroff .tmpl files; empty
+	// uses the built-in messages
+	TemplatesDir string
+
+	// LogLevel is the minimum level written to the log
+	LogLevel slog.Level
+
+	// DryRun logs outgoing messages instead of sending them; polling and
+	// commands work as usual
+	DryRun bool
+
+	// MetricsAddr is the listen address of the Prometheus endpoint; empty disables it
+	MetricsAddr string
+	// HealthAddr is the listen address of the /healthz endpoint; empty disables it
+	HealthAddr string
 }
 
-func LoadConfig() (*Config, error) {
+// LoadConfig reads the configuration from env variables (and .env). If
+// configPath is set, that YAML file supplies values for unset variables.
+func LoadConfig(configPath string) (*Config, error) {
 	_ = godotenv.Load()
+	if configPath != "" {
+		if err := applyConfigFile(configPath); err != nil {
+			return nil, err
+		}
+	}
 
 	var err error
+	var missing []string // required variables that are unset
 
 	var stationID int64
 	if v := os.Getenv("DEYE_STATION_ID"); v != "" {
@@ -42,11 +187,27 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 
-	userIDs, err := parseUserIDs(os.Getenv("TELEGRAM_USER_IDS"))
+	stations, err := parseStations(os.Getenv("DEYE_STATIONS"))
 	if err != nil {
+		return nil, fmt.Errorf("invalid DEYE_STATIONS: %w", err)
+	}
+
+	// Blank or only commas counts as unset
+	userIDs, err := parseUserIDs(os.Getenv("TELEGRAM_USER_IDS"))
+	if errors.Is(err, errNoUserIDs) {
+		missing = append(missing, "TELEGRAM_USER_IDS")
+	} else if err != nil {
 		return nil, fmt.Errorf("invalid TELEGRAM_USER_IDS: %w", err)
 	}
 
+	adminIDs := userIDs
+	if v := os.Getenv("ADMIN_USER_IDS"); v != "" {
+		adminIDs, err = parseUserIDs(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ADMIN_USER_IDS: %w", err)
+		}
+	}
+
 	pollInterval := 60
 	if v := os.Getenv("POLL_INTERVAL_SEC"); v != "" {
 		pollInterval, err = strconv.Atoi(v)
@@ -55,30 +216,646 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 
+	var pollSchedule []PollWindow
+	if v := os.Getenv("POLL_SCHEDULE"); v != "" {
+		pollSchedule, err = parsePollSchedule(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POLL_SCHEDULE: %w", err)
+		}
+	}
+
+	broadcastDedupe := 300
+	if v := os.Getenv("BROADCAST_DEDUPE_SEC"); v != "" {
+		broadcastDedupe, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BROADCAST_DEDUPE_SEC: %w", err)
+		}
+	}
+
+	pollJitter := 0
+	if v := os.Getenv("POLL_JITTER_PERCENT"); v != "" {
+		pollJitter, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POLL_JITTER_PERCENT: %w", err)
+		}
+	}
+
+	gridDebounce := 0
+	if v := os.Getenv("GRID_DEBOUNCE_SEC"); v != "" {
+		gridDebounce, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GRID_DEBOUNCE_SEC: %w", err)
+		}
+	}
+
+	gridThresholds := GridThresholds{
+		FallbackMaxDischargeW: 20,
+		FallbackMaxSOCDrop:    1,
+	}
+	switch v := os.Getenv("GRID_POWER_SIGN"); v {
+	case "", "import-positive":
+	case "export-positive":
+		gridThresholds.GridExportPositive = true
+	default:
+		return nil, fmt.Errorf("invalid GRID_POWER_SIGN %q: want import-positive or export-positive", v)
+	}
+	if v := os.Getenv("GRID_POWER_THRESHOLD_W"); v != "" {
+		gridThresholds.MinGridPowerW, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GRID_POWER_THRESHOLD_W: %w", err)
+		}
+	}
+	if v := os.Getenv("GRID_FALLBACK_MAX_DISCHARGE_W"); v != "" {
+		gridThresholds.FallbackMaxDischargeW, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GRID_FALLBACK_MAX_DISCHARGE_W: %w", err)
+		}
+	}
+	if v := os.Getenv("GRID_FALLBACK_MAX_SOC_DROP"); v != "" {
+		gridThresholds.FallbackMaxSOCDrop, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GRID_FALLBACK_MAX_SOC_DROP: %w", err)
+		}
+	}
+
+	batteryLowSOC := 20.0
+	if v := os.Getenv("BATTERY_LOW_SOC"); v != "" {
+		batteryLowSOC, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BATTERY_LOW_SOC: %w", err)
+		}
+	}
+
+	var quietHours *ClockRange
+	if v := os.Getenv("QUIET_HOURS"); v != "" {
+		quietHours, err = parseClockRange(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid QUIET_HOURS: %w", err)
+		}
+	}
+
+	var telegramThreadID int64
+	if v := os.Getenv("TELEGRAM_THREAD_ID"); v != "" {
+		telegramThreadID, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TELEGRAM_THREAD_ID: %w", err)
+		}
+	}
+
+	telegramRateLimit := 25.0
+	if v := os.Getenv("TELEGRAM_RATE_LIMIT"); v != "" {
+		telegramRateLimit, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TELEGRAM_RATE_LIMIT: %w", err)
+		}
+	}
+
+	// TZ is how Go itself picks the local zone; TIMEZONE wins over it
+	tzName := envOr("TIMEZONE", envOr("TZ", "Europe/Kyiv"))
+	location, err := time.LoadLocation(tzName)
+	if err != nil {
+		slog.Warn("Unknown time zone, using UTC", "zone", tzName, "err", err)
+		location = time.UTC
+	}
+
+	var logLevel slog.Level
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := logLevel.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+		}
+	}
+
+	lang := defaultLang
+	if v := os.Getenv("SVITLO_LANG"); v != "" {
+		var ok bool
+		if lang, ok = parseLang(v); !ok {
+			return nil, fmt.Errorf("invalid SVITLO_LANG: %q, expected one of: %s", v, langNames())
+		}
+	}
+
+	var dryRun bool
+	if v := os.Getenv("DRY_RUN"); v != "" {
+		dryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DRY_RUN: %w", err)
+		}
+	}
+
+	var dailySummaryAt *time.Duration
+	if v := os.Getenv("DAILY_SUMMARY_AT"); v != "" {
+		at, err := parseClock(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DAILY_SUMMARY_AT: %w", err)
+		}
+		dailySummaryAt = &at
+	}
+
+	var monthlyReportAt *time.Duration
+	if v := os.Getenv("MONTHLY_REPORT_AT"); v != "" {
+		at, err := parseClock(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MONTHLY_REPORT_AT: %w", err)
+		}
+		monthlyReportAt = &at
+	}
+
+	dtekEnabled := true
+	if v := os.Getenv("DTEK_ENABLED"); v != "" {
+		dtekEnabled, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DTEK_ENABLED: %w", err)
+		}
+	}
+	dtekCity := os.Getenv("DTEK_CITY")
+	dtekStreet := os.Getenv("DTEK_STREET")
+	dtekHouse := os.Getenv("DTEK_HOUSE")
+	if dtekEnabled && (dtekCity == "" || dtekStreet == "" || dtekHouse == "") {
+		return nil, fmt.Errorf("DTEK_CITY, DTEK_STREET and DTEK_HOUSE must all be set (or set DTEK_ENABLED=false)")
+	}
+
+	dtekAttempts := 3
+	if v := os.Getenv("DTEK_FETCH_ATTEMPTS"); v != "" {
+		dtekAttempts, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DTEK_FETCH_ATTEMPTS: %w", err)
+		}
+	}
+
+	dtekCacheTTL := 10 * time.Minute
+	if v := os.Getenv("DTEK_CACHE_TTL"); v != "" {
+		dtekCacheTTL, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DTEK_CACHE_TTL: %w", err)
+		}
+	}
+
+	dtekMaxStale := 2 * time.Hour
+	if v := os.Getenv("DTEK_MAX_STALE"); v != "" {
+		dtekMaxStale, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DTEK_MAX_STALE: %w", err)
+		}
+	}
+
+	dtekBreakerFailures := 3
+	if v := os.Getenv("DTEK_BREAKER_FAILURES"); v != "" {
+		dtekBreakerFailures, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DTEK_BREAKER_FAILURES: %w", err)
+		}
+	}
+
+	dtekBreakerCooldown := 15 * time.Minute
+	if v := os.Getenv("DTEK_BREAKER_COOLDOWN"); v != "" {
+		dtekBreakerCooldown, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DTEK_BREAKER_COOLDOWN: %w", err)
+		}
+	}
+
+	var proxyURL *url.URL
+	if v := os.Getenv("PROXY_URL"); v != "" {
+		proxyURL, err = url.Parse(v)
+		if err != nil || proxyURL.Host == "" ||
+			(proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5") {
+			return nil, fmt.Errorf("invalid PROXY_URL: %q is not an http(s) or socks5 URL", v)
+		}
+	}
+
+	var deyeTLS *tls.Config
+	caPath, pins := os.Getenv("DEYE_CA_CERT"), os.Getenv("DEYE_CERT_PINS")
+	if caPath != "" || pins != "" {
+		var caPEM []byte
+		if caPath != "" {
+			if caPEM, err = os.ReadFile(caPath); err != nil {
+				return nil, fmt.Errorf("invalid DEYE_CA_CERT: %w", err)
+			}
+		}
+		var pinList []string
+		if pins != "" {
+			pinList = strings.Split(pins, ",")
+		}
+		if deyeTLS, err = pinnedTLSConfig(caPEM, pinList); err != nil {
+			return nil, fmt.Errorf("invalid DEYE_CA_CERT or DEYE_CERT_PINS: %w", err)
+		}
+	}
+
+	deyeStaleAfter := 15 * time.Minute
+	if v := os.Getenv("DEYE_STALE_AFTER"); v != "" {
+		deyeStaleAfter, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEYE_STALE_AFTER: %w", err)
+		}
+	}
+
+	var batteryCapacity float64
+	if v := os.Getenv("DEYE_BATTERY_CAPACITY_WH"); v != "" {
+		batteryCapacity, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEYE_BATTERY_CAPACITY_WH: %w", err)
+		}
+	}
+
+	tokenCache := ".deye-token.json"
+	if v, ok := os.LookupEnv("DEYE_TOKEN_CACHE"); ok {
+		tokenCache = v
+	}
+
+	// Replays and Home Assistant don't talk to Deye Cloud, so its
+	// credentials are optional then
+	replayFile := os.Getenv("DEYE_REPLAY_FILE")
+	haURL := os.Getenv("HA_URL")
+	deyeEnv := requiredEnv
+	if replayFile != "" || haURL != "" {
+		deyeEnv = func(key string, _ *[]string) string { return os.Getenv(key) }
+	}
+
 	cfg := &Config{
-		DeyeBaseURL:      requiredEnv("DEYE_BASE_URL"),
-		DeyeAppID:        requiredEnv("DEYE_APP_ID"),
-		DeyeAppSecret:    requiredEnv("DEYE_APP_SECRET"),
-		DeyeEmail:        requiredEnv("DEYE_EMAIL"),
-		DeyePassword:     requiredEnv("DEYE_PASSWORD"),
-		DeyeStationID:    stationID,
-		DeyeDeviceSN:     os.Getenv("DEYE_DEVICE_SN"),
-		TelegramBotToken: requiredEnv("TELEGRAM_BOT_TOKEN"),
-		TelegramUserIDs:  userIDs,
-		PollIntervalSec:  pollInterval,
+		DeyeBaseURL:    deyeEnv("DEYE_BASE_URL", &missing),
+		DeyeAppID:      deyeEnv("DEYE_APP_ID", &missing),
+		DeyeAppSecret:  deyeEnv("DEYE_APP_SECRET", &missing),
+		DeyeEmail:      deyeEnv("DEYE_EMAIL", &missing),
+		DeyePassword:   deyeEnv("DEYE_PASSWORD", &missing),
+		DeyeTokenCache: tokenCache,
+		DeyeTLS:        deyeTLS,
+		DeyeStaleAfter: deyeStaleAfter,
+		DeyeReplayFile: replayFile,
+		HAURL:          haURL,
+		HAToken:        os.Getenv("HA_TOKEN"),
+		HAEntities: HAEntities{
+			GridPower:    os.Getenv("HA_GRID_POWER_ENTITY"),
+			BatterySOC:   os.Getenv("HA_BATTERY_SOC_ENTITY"),
+			Consumption:  os.Getenv("HA_CONSUMPTION_ENTITY"),
+			Generation:   os.Getenv("HA_GENERATION_ENTITY"),
+			BatteryPower: os.Getenv("HA_BATTERY_POWER_ENTITY"),
+		},
+		DeyeStationID:         stationID,
+		DeyeDeviceSN:          os.Getenv("DEYE_DEVICE_SN"),
+		Stations:              stations,
+		DeyeBatteryCapacityWh: batteryCapacity,
+		TelegramBotToken:      requiredEnv("TELEGRAM_BOT_TOKEN", &missing),
+		TelegramUserIDs:       userIDs,
+		AdminUserIDs:          adminIDs,
+		TelegramThreadID:      telegramThreadID,
+		TelegramRateLimit:     telegramRateLimit,
+		ProxyURL:              proxyURL,
+		DiscordWebhookURL:     os.Getenv("DISCORD_WEBHOOK_URL"),
+		NtfyURL:               os.Getenv("NTFY_URL"),
+		NtfyTopic:             os.Getenv("NTFY_TOPIC"),
+		NtfyToken:             os.Getenv("NTFY_TOKEN"),
+		PollIntervalSec:       pollInterval,
+		PollSchedule:          pollSchedule,
+		PollJitterPercent:     pollJitter,
+		GridDebounceSec:       gridDebounce,
+		BroadcastDedupeSec:    broadcastDedupe,
+		GridThresholds:        gridThresholds,
+		BatteryLowSOC:         batteryLowSOC,
+		QuietHours:            quietHours,
+		DailySummaryAt:        dailySummaryAt,
+		MonthlyReportAt:       monthlyReportAt,
+		DtekEnabled:           dtekEnabled,
+		Provider:              envOr("PROVIDER", "dtek-dnipro"),
+		DtekCity:              dtekCity,
+		DtekStreet:            dtekStreet,
+		DtekHouse:             dtekHouse,
+		DtekFetchAttempts:     dtekAttempts,
+		DtekMaxStale:          dtekMaxStale,
+		DtekCacheTTL:          dtekCacheTTL,
+		DtekBreakerFailures:   dtekBreakerFailures,
+		DtekBreakerCooldown:   dtekBreakerCooldown,
+		DBPath:                envOr("DB_PATH", "svitlo.db"),
+		SettingsPath:          envOr("SETTINGS_PATH", "settings.json"),
+		Lang:                  lang,
+		TemplatesDir:          os.Getenv("TEMPLATES_DIR"),
+		LogLevel:              logLevel,
+		Location:              location,
+		DryRun:                dryRun,
+		WebhookURL:            os.Getenv("WEBHOOK_URL"),
+		WebhookListenAddr:     envOr("WEBHOOK_LISTEN_ADDR", ":8443"),
+		MetricsAddr:           os.Getenv("METRICS_ADDR"),
+		HealthAddr:            os.Getenv("HEALTH_ADDR"),
+	}
+
+	if len(missing) > 0 {
+		if !slices.Contains(missing, "TELEGRAM_USER_IDS") {
+			return nil, fmt.Errorf("missing required env vars: %s", strings.Join(missing, ", "))
+		}
+		if len(missing) == 1 {
+			return nil, errors.New(telegramUserIDsHint)
+		}
+		return nil, fmt.Errorf("missing required env vars: %s (%s)", strings.Join(missing, ", "), telegramUserIDsHint)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
 	}
 
 	return cfg, nil
 }
 
-func requiredEnv(key string) string {
+// minPollIntervalSec keeps the poller from hammering the Deye API
+const minPollIntervalSec = 5
+
+var telegramTokenRe = regexp.MustCompile(`^\d+:[\w-]+$`)
+
+// validate checks values that parse fine but can't work.
+func (c *Config) validate() error {
+	if c.PollIntervalSec < minPollIntervalSec {
+		return fmt.Errorf("invalid POLL_INTERVAL_SEC: must be at least %d, got %d", minPollIntervalSec, c.PollIntervalSec)
+	}
+	for _, w := range c.PollSchedule {
+		if w.IntervalSec < minPollIntervalSec {
+			return fmt.Errorf("invalid POLL_SCHEDULE: interval for %s must be at least %d, got %d", w.Hours, minPollIntervalSec, w.IntervalSec)
+		}
+	}
+	if c.BroadcastDedupeSec < 0 {
+		return fmt.Errorf("invalid BROADCAST_DEDUPE_SEC: must not be negative, got %d", c.BroadcastDedupeSec)
+	}
+	if c.PollJitterPercent < 0 || c.PollJitterPercent > 50 {
+		return fmt.Errorf("invalid POLL_JITTER_PERCENT: must be between 0 and 50, got %d", c.PollJitterPercent)
+	}
+	if c.DtekBreakerFailures < 0 {
+		return fmt.Errorf("invalid DTEK_BREAKER_FAILURES: must not be negative, got %d", c.DtekBreakerFailures)
+	}
+
+	// A replay file or Home Assistant stands in for Deye Cloud
+	if c.DeyeReplayFile == "" && c.HAURL == "" {
+		u, err := url.Parse(c.DeyeBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid DEYE_BASE_URL: %q is not an http(s) URL", c.DeyeBaseURL)
+		}
+	}
+
+	if c.WebhookURL != "" {
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid WEBHOOK_URL: %q is not an https URL", c.WebhookURL)
+		}
+	}
+
+	if c.HAURL != "" {
+		u, err := url.Parse(c.HAURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid HA_URL: %q is not an http(s) URL", c.HAURL)
+		}
+		if c.DeyeReplayFile != "" {
+			return fmt.Errorf("HA_URL and DEYE_REPLAY_FILE cannot be used together")
+		}
+		var missing []string
+		for key, v := range map[string]string{
+			"HA_TOKEN":              c.HAToken,
+			"HA_GRID_POWER_ENTITY":  c.HAEntities.GridPower,
+			"HA_BATTERY_SOC_ENTITY": c.HAEntities.BatterySOC,
+			"HA_CONSUMPTION_ENTITY": c.HAEntities.Consumption,
+		} {
+			if v == "" {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 {
+			slices.Sort(missing)
+			return fmt.Errorf("%s must be set when HA_URL is", strings.Join(missing, ", "))
+		}
+	}
+
+	if c.DiscordWebhookURL != "" {
+		u, err := url.Parse(c.DiscordWebhookURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid DISCORD_WEBHOOK_URL: %q is not an https URL", c.DiscordWebhookURL)
+		}
+	}
+
+	if c.NtfyURL != "" {
+		u, err := url.Parse(c.NtfyURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid NTFY_URL: %q is not an http(s) URL", c.NtfyURL)
+		}
+		if c.NtfyTopic == "" {
+			return fmt.Errorf("NTFY_TOPIC must be set when NTFY_URL is")
+		}
+	}
+
+	if c.DtekEnabled {
+		if _, ok := dtekSites[c.Provider]; !ok {
+			return fmt.Errorf("invalid PROVIDER: %q, expected one of: %s", c.Provider, providerNames())
+		}
+	}
+
+	if c.BatteryLowSOC < 0 || c.BatteryLowSOC >= 100 {
+		return fmt.Errorf("invalid BATTERY_LOW_SOC: must be between 0 and 100, got %g", c.BatteryLowSOC)
+	}
+
+	for _, id := range c.AdminUserIDs {
+		if !slices.Contains(c.TelegramUserIDs, id) {
+			return fmt.Errorf("invalid ADMIN_USER_IDS: %d is not in TELEGRAM_USER_IDS", id)
+		}
+	}
+
+	if c.TelegramRateLimit <= 0 {
+		return fmt.Errorf("invalid TELEGRAM_RATE_LIMIT: must be positive, got %g", c.TelegramRateLimit)
+	}
+
+	if !telegramTokenRe.MatchString(c.TelegramBotToken) {
+		return fmt.Errorf("invalid TELEGRAM_BOT_TOKEN: expected the <digits>:<secret> token from @BotFather")
+	}
+	return nil
+}
+
+// Station is a single Deye inverter monitored by the bot.
+type Station struct {
+	ID       int64
+	DeviceSN string
+	Label    string // prefixed to notifications; empty for single-station setups
+}
+
+func (s Station) key() string {
+	return fmt.Sprintf("%d:%s", s.ID, s.DeviceSN)
+}
+
+// name is the station label, or its ID when no label is set.
+func (s Station) name() string {
+	if s.Label != "" {
+		return s.Label
+	}
+	return strconv.FormatInt(s.ID, 10)
+}
+
+// parseStations parses "stationID:deviceSN[:label]" entries separated by commas.
+func parseStations(s string) ([]Station, error) {
+	var stations []Station
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		fields := strings.SplitN(p, ":", 3)
+		if len(fields) < 2 || fields[1] == "" {
+			return nil, fmt.Errorf("entry %q must be stationID:deviceSN[:label]", p)
+		}
+		id, err := strconv.ParseInt(strings.TrimSpace(fields[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse station ID in %q: %w", p, err)
+		}
+		st := Station{ID: id, DeviceSN: strings.TrimSpace(fields[1])}
+		if len(fields) == 3 {
+			st.Label = strings.TrimSpace(fields[2])
+		}
+		stations = append(stations, st)
+	}
+	return stations, nil
+}
+
+// ClockRange is a daily time range, possibly wrapping midnight (23:00-07:00).
+type ClockRange struct {
+	Start time.Duration // offset from midnight
+	End   time.Duration
+}
+
+// Contains reports whether t's wall-clock time falls within the range.
+func (q ClockRange) Contains(t time.Time) bool {
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if q.Start <= q.End {
+		return tod >= q.Start && tod < q.End
+	}
+	return tod >= q.Start || tod < q.End
+}
+
+func (q ClockRange) String() string {
+	return formatClock(q.Start) + "-" + formatClock(q.End)
+}
+
+// parseClockRange parses "HH:MM-HH:MM".
+func parseClockRange(s string) (*ClockRange, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("%q must look like 23:00-07:00", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("%q is an empty range", s)
+	}
+	return &ClockRange{Start: start, End: end}, nil
+}
+
+// PollWindow is a daily time range with its own poll interval.
+type PollWindow struct {
+	Hours       ClockRange
+	IntervalSec int
+}
+
+// parsePollSchedule parses "HH:MM-HH:MM=seconds" entries separated by
+// commas, e.g. "17:00-23:00=30,23:00-07:00=300".
+func parsePollSchedule(s string) ([]PollWindow, error) {
+	var windows []PollWindow
+	for _, entry := range strings.Split(s, ",") {
+		hours, secs, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("%q must look like 17:00-23:00=30", entry)
+		}
+		r, err := parseClockRange(hours)
+		if err != nil {
+			return nil, err
+		}
+		interval, err := strconv.Atoi(strings.TrimSpace(secs))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval in %q: %w", entry, err)
+		}
+		windows = append(windows, PollWindow{Hours: *r, IntervalSec: interval})
+	}
+	return windows, nil
+}
+
+// PollInterval returns the poll interval in effect at t: that of the first
+// POLL_SCHEDULE window containing t, else POLL_INTERVAL_SEC.
+func (c *Config) PollInterval(t time.Time) time.Duration {
+	for _, w := range c.PollSchedule {
+		if w.Hours.Contains(t) {
+			return time.Duration(w.IntervalSec) * time.Second
+		}
+	}
+	return time.Duration(c.PollIntervalSec) * time.Second
+}
+
+// MaxPollInterval is the longest poll interval at any time of day.
+func (c *Config) MaxPollInterval() time.Duration {
+	longest := c.PollIntervalSec
+	for _, w := range c.PollSchedule {
+		longest = max(longest, w.IntervalSec)
+	}
+	return time.Duration(longest) * time.Second
+}
+
+// MinPollInterval is the shortest poll interval at any time of day.
+func (c *Config) MinPollInterval() time.Duration {
+	shortest := c.PollIntervalSec
+	for _, w := range c.PollSchedule {
+		shortest = min(shortest, w.IntervalSec)
+	}
+	return time.Duration(shortest) * time.Second
+}
+
+// untilPollChange returns how long after t the next POLL_SCHEDULE window
+// starts or ends, or 0 without a schedule.
+func (c *Config) untilPollChange(t time.Time) time.Duration {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	var soonest time.Duration
+	for _, w := range c.PollSchedule {
+		for _, offset := range []time.Duration{w.Hours.Start, w.Hours.End} {
+			next := midnight.Add(offset)
+			if !next.After(t) {
+				next = next.AddDate(0, 0, 1)
+			}
+			if d := next.Sub(t); soonest == 0 || d < soonest {
+				soonest = d
+			}
+		}
+	}
+	return soonest
+}
+
+// parseClock parses "HH:MM" into an offset from midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: %w", s, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func formatClock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// requiredEnv returns the variable's value, adding key to missing if it is unset.
+func requiredEnv(key string, missing *[]string) string {
 	v := os.Getenv(key)
 	if v == "" {
-		panic(fmt.Sprintf("required env variable %s is not set", key))
+		*missing = append(*missing, key)
 	}
 	return v
 }
 
+// telegramUserIDsHint explains the one required variable a new user can't
+// simply copy from a dashboard.
+const telegramUserIDsHint = "TELEGRAM_USER_IDS is required — set it to your numeric chat ID; message @userinfobot to find it"
+
+var errNoUserIDs = errors.New("no user IDs provided")
+
+// parseUserIDs parses comma-separated chat IDs, skipping empty entries such
+// as the one after a trailing comma.
 func parseUserIDs(s string) ([]int64, error) {
 	parts := strings.Split(s, ",")
 	ids := make([]int64, 0, len(parts))
@@ -94,7 +871,7 @@ func parseUserIDs(s string) ([]int64, error) {
 		ids = append(ids, id)
 	}
 	if len(ids) == 0 {
-		return nil, fmt.Errorf("no user IDs provided")
+		return nil, errNoUserIDs
 	}
 	return ids, nil
 }
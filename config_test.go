@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+// setMinimalEnv sets the Telegram variables every mode requires, turns DTEK
+// off and blanks the Deye ones.
+func setMinimalEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("TELEGRAM_BOT_TOKEN", "123456:test-token")
+	t.Setenv("TELEGRAM_USER_IDS", "1")
+	t.Setenv("DTEK_ENABLED", "false")
+	for _, key := range []string{"DEYE_BASE_URL", "DEYE_APP_ID", "DEYE_APP_SECRET", "DEYE_EMAIL", "DEYE_PASSWORD", "DEYE_REPLAY_FILE", "HA_URL"} {
+		t.Setenv(key, "")
+	}
+}
+
+func TestLoadConfigReplayWithoutDeye(t *testing.T) {
+	setMinimalEnv(t)
+	t.Setenv("DEYE_REPLAY_FILE", "testdata/flapping.jsonl")
+
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.DeyeReplayFile != "testdata/flapping.jsonl" {
+		t.Errorf("DeyeReplayFile = %q", cfg.DeyeReplayFile)
+	}
+}
+
+func TestLoadConfigHomeAssistantWithoutDeye(t *testing.T) {
+	setMinimalEnv(t)
+	t.Setenv("HA_URL", "http://homeassistant.local:8123")
+	t.Setenv("HA_TOKEN", "token")
+	t.Setenv("HA_GRID_POWER_ENTITY", "sensor.grid_power")
+	t.Setenv("HA_BATTERY_SOC_ENTITY", "sensor.battery_soc")
+	t.Setenv("HA_CONSUMPTION_ENTITY", "sensor.load_power")
+
+	cfg, err := LoadConfig("")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.HAURL != "http://homeassistant.local:8123" {
+		t.Errorf("HAURL = %q", cfg.HAURL)
+	}
+}
+
+func TestLoadConfigDeyeBaseURL(t *testing.T) {
+	setMinimalEnv(t)
+	t.Setenv("DEYE_BASE_URL", "eu1-developer.deyecloud.com")
+	t.Setenv("DEYE_APP_ID", "id")
+	t.Setenv("DEYE_APP_SECRET", "secret")
+	t.Setenv("DEYE_EMAIL", "user@example.com")
+	t.Setenv("DEYE_PASSWORD", "password")
+
+	if _, err := LoadConfig(""); err == nil {
+		t.Error("want an error for a DEYE_BASE_URL without a scheme")
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the layout of the optional YAML config file. Every field
+// mirrors an env variable; LoadConfig reads the file by exporting its values
+// into the environment, so env vars keep the final say.
+type fileConfig struct {
+	Deye struct {
+		BaseURL           string        `yaml:"base_url"`
+		AppID             string        `yaml:"app_id"`
+		AppSecret         string        `yaml:"app_secret"`
+		Email             string        `yaml:"email"`
+		Password          string        `yaml:"password"`
+		TokenCache        *string       `yaml:"token_cache"` // empty disables the cache
+		StaleAfter        string        `yaml:"stale_after"`
+		CACert            string        `yaml:"ca_cert"`
+		CertPins          []string      `yaml:"cert_pins"`
+		ReplayFile        string        `yaml:"replay_file"`
+		StationID         int64         `yaml:"station_id"`
+		DeviceSN          string        `yaml:"device_sn"`
+		Stations          []fileStation `yaml:"stations"`
+		BatteryCapacityWh float64       `yaml:"battery_capacity_wh"`
+	} `yaml:"deye"`
+
+	Telegram struct {
+		BotToken  string   `yaml:"bot_token"`
+		UserIDs   []int64  `yaml:"user_ids"`
+		AdminIDs  []int64  `yaml:"admin_ids"`
+		ThreadID  int64    `yaml:"thread_id"`
+		RateLimit *float64 `yaml:"rate_limit"`
+		// Webhook mode instead of long polling
+		WebhookURL        string `yaml:"webhook_url"`
+		WebhookListenAddr string `yaml:"webhook_listen_addr"`
+	} `yaml:"telegram"`
+
+	Discord struct {
+		WebhookURL string `yaml:"webhook_url"`
+	} `yaml:"discord"`
+
+	HomeAssistant struct {
+		URL      string `yaml:"url"`
+		Token    string `yaml:"token"`
+		Entities struct {
+			GridPower    string `yaml:"grid_power"`
+			BatterySOC   string `yaml:"battery_soc"`
+			Consumption  string `yaml:"consumption"`
+			Generation   string `yaml:"generation"`
+			BatteryPower string `yaml:"battery_power"`
+		} `yaml:"entities"`
+	} `yaml:"home_assistant"`
+
+	Ntfy struct {
+		URL   string `yaml:"url"`
+		Topic string `yaml:"topic"`
+		Token string `yaml:"token"`
+	} `yaml:"ntfy"`
+
+	PollIntervalSec   int              `yaml:"poll_interval_sec"`
+	PollJitterPercent int              `yaml:"poll_jitter_percent"`
+	PollSchedule      []filePollWindow `yaml:"poll_schedule"`
+
+	Grid struct {
+		DebounceSec           int      `yaml:"debounce_sec"`
+		PowerSign             string   `yaml:"power_sign"`
+		PowerThresholdW       *float64 `yaml:"power_threshold_w"`
+		FallbackMaxDischargeW *float64 `yaml:"fallback_max_discharge_w"`
+		FallbackMaxSOCDrop    *float64 `yaml:"fallback_max_soc_drop"`
+	} `yaml:"grid"`
+
+	BroadcastDedupeSec *int64 `yaml:"broadcast_dedupe_sec"`
+	ProxyURL           string `yaml:"proxy_url"`
+
+	BatteryLowSOC   *float64 `yaml:"battery_low_soc"`
+	QuietHours      string   `yaml:"quiet_hours"`
+	DailySummaryAt  string   `yaml:"daily_summary_at"`
+	MonthlyReportAt string   `yaml:"monthly_report_at"`
+
+	Dtek struct {
+		Enabled       *bool  `yaml:"enabled"`
+		Provider      string `yaml:"provider"`
+		City          string `yaml:"city"`
+		Street        string `yaml:"street"`
+		House         string `yaml:"house"`
+		FetchAttempts int    `yaml:"fetch_attempts"`
+		MaxStale      string `yaml:"max_stale"`
+		CacheTTL      string `yaml:"cache_ttl"`
+		// BreakerFailures is a pointer so 0 can turn the breaker off
+		BreakerFailures *int   `yaml:"breaker_failures"`
+		BreakerCooldown string `yaml:"breaker_cooldown"`
+	} `yaml:"dtek"`
+
+	DBPath       string `yaml:"db_path"`
+	SettingsPath string `yaml:"settings_path"`
+	TemplatesDir string `yaml:"templates_dir"`
+	Lang         string `yaml:"lang"`
+	Timezone     string `yaml:"timezone"`
+	MetricsAddr  string `yaml:"metrics_addr"`
+	HealthAddr   string `yaml:"health_addr"`
+	LogLevel     string `yaml:"log_level"`
+	DryRun       *bool  `yaml:"dry_run"`
+}
+
+type filePollWindow struct {
+	Hours       string `yaml:"hours"`
+	IntervalSec int    `yaml:"interval_sec"`
+}
+
+type fileStation struct {
+	ID       int64  `yaml:"id"`
+	DeviceSN string `yaml:"device_sn"`
+	Label    string `yaml:"label"`
+}
+
+// env converts the file into env variable values. Unset fields are omitted.
+func (f *fileConfig) env() map[string]string {
+	m := make(map[string]string)
+	set := func(key, v string) {
+		if v != "" {
+			m[key] = v
+		}
+	}
+	setInt := func(key string, v int64) {
+		if v != 0 {
+			m[key] = strconv.FormatInt(v, 10)
+		}
+	}
+	setFloat := func(key string, v *float64) {
+		if v != nil {
+			m[key] = strconv.FormatFloat(*v, 'f', -1, 64)
+		}
+	}
+
+	set("DEYE_BASE_URL", f.Deye.BaseURL)
+	set("DEYE_APP_ID", f.Deye.AppID)
+	set("DEYE_APP_SECRET", f.Deye.AppSecret)
+	set("DEYE_EMAIL", f.Deye.Email)
+	set("DEYE_PASSWORD", f.Deye.Password)
+	if f.Deye.TokenCache != nil {
+		m["DEYE_TOKEN_CACHE"] = *f.Deye.TokenCache
+	}
+	set("DEYE_STALE_AFTER", f.Deye.StaleAfter)
+	set("DEYE_CA_CERT", f.Deye.CACert)
+	set("DEYE_CERT_PINS", strings.Join(f.Deye.CertPins, ","))
+	set("DEYE_REPLAY_FILE", f.Deye.ReplayFile)
+	setInt("DEYE_STATION_ID", f.Deye.StationID)
+	set("DEYE_DEVICE_SN", f.Deye.DeviceSN)
+	if len(f.Deye.Stations) > 0 {
+		entries := make([]string, 0, len(f.Deye.Stations))
+		for _, st := range f.Deye.Stations {
+			entry := fmt.Sprintf("%d:%s", st.ID, st.DeviceSN)
+			if st.Label != "" {
+				entry += ":" + st.Label
+			}
+			entries = append(entries, entry)
+		}
+		m["DEYE_STATIONS"] = strings.Join(entries, ",")
+	}
+	if f.Deye.BatteryCapacityWh != 0 {
+		setFloat("DEYE_BATTERY_CAPACITY_WH", &f.Deye.BatteryCapacityWh)
+	}
+
+	set("TELEGRAM_BOT_TOKEN", f.Telegram.BotToken)
+	setIDs := func(key string, ids []int64) {
+		if len(ids) == 0 {
+			return
+		}
+		parts := make([]string, len(ids))
+		for i, id := range ids {
+			parts[i] = strconv.FormatInt(id, 10)
+		}
+		m[key] = strings.Join(parts, ",")
+	}
+	setIDs("TELEGRAM_USER_IDS", f.Telegram.UserIDs)
+	setIDs("ADMIN_USER_IDS", f.Telegram.AdminIDs)
+
+	setInt("TELEGRAM_THREAD_ID", f.Telegram.ThreadID)
+	setFloat("TELEGRAM_RATE_LIMIT", f.Telegram.RateLimit)
+	set("WEBHOOK_URL", f.Telegram.WebhookURL)
+	set("WEBHOOK_LISTEN_ADDR", f.Telegram.WebhookListenAddr)
+	set("DISCORD_WEBHOOK_URL", f.Discord.WebhookURL)
+	set("HA_URL", f.HomeAssistant.URL)
+	set("HA_TOKEN", f.HomeAssistant.Token)
+	set("HA_GRID_POWER_ENTITY", f.HomeAssistant.Entities.GridPower)
+	set("HA_BATTERY_SOC_ENTITY", f.HomeAssistant.Entities.BatterySOC)
+	set("HA_CONSUMPTION_ENTITY", f.HomeAssistant.Entities.Consumption)
+	set("HA_GENERATION_ENTITY", f.HomeAssistant.Entities.Generation)
+	set("HA_BATTERY_POWER_ENTITY", f.HomeAssistant.Entities.BatteryPower)
+
+	set("PROXY_URL", f.ProxyURL)
+	set("NTFY_URL", f.Ntfy.URL)
+	set("NTFY_TOPIC", f.Ntfy.Topic)
+	set("NTFY_TOKEN", f.Ntfy.Token)
+
+	setInt("POLL_INTERVAL_SEC", int64(f.PollIntervalSec))
+	setInt("POLL_JITTER_PERCENT", int64(f.PollJitterPercent))
+	if len(f.PollSchedule) > 0 {
+		entries := make([]string, len(f.PollSchedule))
+		for i, w := range f.PollSchedule {
+			entries[i] = fmt.Sprintf("%s=%d", w.Hours, w.IntervalSec)
+		}
+		m["POLL_SCHEDULE"] = strings.Join(entries, ",")
+	}
+	setInt("GRID_DEBOUNCE_SEC", int64(f.Grid.DebounceSec))
+	if f.BroadcastDedupeSec != nil {
+		m["BROADCAST_DEDUPE_SEC"] = strconv.FormatInt(*f.BroadcastDedupeSec, 10)
+	}
+	set("GRID_POWER_SIGN", f.Grid.PowerSign)
+	setFloat("GRID_POWER_THRESHOLD_W", f.Grid.PowerThresholdW)
+	setFloat("GRID_FALLBACK_MAX_DISCHARGE_W", f.Grid.FallbackMaxDischargeW)
+	setFloat("GRID_FALLBACK_MAX_SOC_DROP", f.Grid.FallbackMaxSOCDrop)
+	setFloat("BATTERY_LOW_SOC", f.BatteryLowSOC)
+	set("QUIET_HOURS", f.QuietHours)
+	set("DAILY_SUMMARY_AT", f.DailySummaryAt)
+	set("MONTHLY_REPORT_AT", f.MonthlyReportAt)
+
+	if f.Dtek.Enabled != nil {
+		m["DTEK_ENABLED"] = strconv.FormatBool(*f.Dtek.Enabled)
+	}
+	set("PROVIDER", f.Dtek.Provider)
+	set("DTEK_CITY", f.Dtek.City)
+	set("DTEK_STREET", f.Dtek.Street)
+	set("DTEK_HOUSE", f.Dtek.House)
+	setInt("DTEK_FETCH_ATTEMPTS", int64(f.Dtek.FetchAttempts))
+	set("DTEK_MAX_STALE", f.Dtek.MaxStale)
+	set("DTEK_CACHE_TTL", f.Dtek.CacheTTL)
+	if f.Dtek.BreakerFailures != nil {
+		m["DTEK_BREAKER_FAILURES"] = strconv.Itoa(*f.Dtek.BreakerFailures)
+	}
+	set("DTEK_BREAKER_COOLDOWN", f.Dtek.BreakerCooldown)
+
+	set("DB_PATH", f.DBPath)
+	set("SETTINGS_PATH", f.SettingsPath)
+	set("TEMPLATES_DIR", f.TemplatesDir)
+	set("SVITLO_LANG", f.Lang)
+	set("TIMEZONE", f.Timezone)
+	set("METRICS_ADDR", f.MetricsAddr)
+	set("HEALTH_ADDR", f.HealthAddr)
+	set("LOG_LEVEL", f.LogLevel)
+	if f.DryRun != nil {
+		m["DRY_RUN"] = strconv.FormatBool(*f.DryRun)
+	}
+	return m
+}
+
+// applyConfigFile exports the file's values for env variables that are not
+// already set.
+func applyConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	var f fileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	for key, v := range f.env() {
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+		if err := os.Setenv(key, v); err != nil {
+			return fmt.Errorf("set %s: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -2,15 +2,22 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
 type DeyeClient struct {
@@ -20,26 +27,144 @@ type DeyeClient struct {
 	email     string
 	password  string
 
-	mu          sync.Mutex
-	accessToken string
-	expiresAt   time.Time
-	httpClient  *http.Client
+	// authMu makes concurrent requests that find the token expired wait
+	// for one re-authentication instead of each doing their own
+	authMu sync.Mutex
+
+	mu           sync.Mutex
+	accessToken  string
+	refreshToken string
+	expiresAt    time.Time
+	httpClient   *http.Client
+
+	tokenCachePath string
+	gridThresholds GridThresholds
+
+	statusCache    map[string]cachedPowerStatus // keyed by "stationID:deviceSN"
+	statusCacheTTL time.Duration
+}
 
-	cachedStatus    *PowerStatus
-	cacheExpireAt   time.Time
+type cachedPowerStatus struct {
+	status   *PowerStatus
+	expireAt time.Time
 }
 
 func NewDeyeClient(cfg *Config) *DeyeClient {
-	return &DeyeClient{
-		baseURL:   cfg.DeyeBaseURL,
-		appID:     cfg.DeyeAppID,
-		appSecret: cfg.DeyeAppSecret,
-		email:     cfg.DeyeEmail,
-		password:  cfg.DeyePassword,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+	c := &DeyeClient{
+		baseURL:        cfg.DeyeBaseURL,
+		appID:          cfg.DeyeAppID,
+		appSecret:      cfg.DeyeAppSecret,
+		email:          cfg.DeyeEmail,
+		password:       cfg.DeyePassword,
+		httpClient:     newTLSHTTPClient(30*time.Second, cfg.ProxyURL, cfg.DeyeTLS),
+		tokenCachePath: cfg.DeyeTokenCache,
+		gridThresholds: cfg.GridThresholds,
+		statusCache:    make(map[string]cachedPowerStatus),
+		statusCacheTTL: statusCacheTTL(cfg),
+	}
+	c.loadTokenCache()
+	return c
+}
+
+// statusCacheTTL is how long GetPowerStatus reuses a reading: a minute, or
+// half the shortest poll interval so that every poll, even a jittered one,
+// gets fresh data.
+func statusCacheTTL(cfg *Config) time.Duration {
+	if d := cfg.MinPollInterval() / 2; d > 0 && d < time.Minute {
+		return d
+	}
+	return time.Minute
+}
+
+// HasValidToken reports whether the client holds a non-expired access token,
+// e.g. one restored from the token cache file.
+func (c *DeyeClient) HasValidToken() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.accessToken != "" && time.Now().Before(c.expiresAt)
+}
+
+// --- Token cache ---
+
+type tokenCache struct {
+	AppID        string    `json:"appId"`
+	Email        string    `json:"email"`
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+func (c *DeyeClient) loadTokenCache() {
+	if c.tokenCachePath == "" {
+		return
+	}
+
+	data, err := os.ReadFile(c.tokenCachePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("[deye] Failed to read token cache", "path", c.tokenCachePath, "err", err)
+		}
+		return
+	}
+
+	var tc tokenCache
+	if err := json.Unmarshal(data, &tc); err != nil {
+		slog.Warn("[deye] Ignoring corrupt token cache", "path", c.tokenCachePath, "err", err)
+		return
+	}
+
+	// Only trust tokens issued for the same account and app
+	if tc.AppID != c.appID || tc.Email != c.email {
+		slog.Info("[deye] Ignoring token cache issued for a different account", "path", c.tokenCachePath)
+		return
 	}
+	if tc.AccessToken == "" || !time.Now().Before(tc.ExpiresAt) {
+		slog.Info("[deye] Ignoring token cache with missing or expired token", "path", c.tokenCachePath)
+		return
+	}
+
+	c.mu.Lock()
+	c.accessToken = tc.AccessToken
+	c.refreshToken = tc.RefreshToken
+	c.expiresAt = tc.ExpiresAt
+	c.mu.Unlock()
+
+	slog.Info("[deye] Loaded cached token", "expires", tc.ExpiresAt.Format("2006-01-02 15:04"))
+}
+
+// saveTokenCache writes the current tokens to disk. Caller must hold c.mu.
+func (c *DeyeClient) saveTokenCache() {
+	if c.tokenCachePath == "" {
+		return
+	}
+
+	data, err := json.Marshal(tokenCache{
+		AppID:        c.appID,
+		Email:        c.email,
+		AccessToken:  c.accessToken,
+		RefreshToken: c.refreshToken,
+		ExpiresAt:    c.expiresAt,
+	})
+	if err != nil {
+		slog.Error("[deye] Failed to marshal token cache", "err", err)
+		return
+	}
+
+	if err := writeFileAtomic(c.tokenCachePath, data); err != nil {
+		slog.Error("[deye] Failed to write token cache", "err", err)
+	}
+}
+
+// --- Log redaction ---
+
+const redactedValue = "***"
+
+// secretFieldRe matches JSON string fields that must never reach the logs.
+var secretFieldRe = regexp.MustCompile(`"(password|appSecret|accessToken|refreshToken)"(\s*:\s*)"[^"]*"`)
+
+// redactSecrets masks credentials and tokens in a JSON payload before it is logged.
+func redactSecrets(payload []byte) string {
+	return secretFieldRe.ReplaceAllString(string(payload), `"$1"$2"`+redactedValue+`"`)
 }
 
 // --- Auth ---
@@ -64,7 +189,7 @@ func sha256Hex(s string) string {
 	return fmt.Sprintf("%x", h[:])
 }
 
-func (c *DeyeClient) Authenticate() error {
+func (c *DeyeClient) Authenticate(ctx context.Context) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -74,67 +199,161 @@ func (c *DeyeClient) Authenticate() error {
 		Password:  sha256Hex(c.password),
 	}
 
+	url := fmt.Sprintf("%s/v1.0/account/token?appId=%s", c.baseURL, c.appID)
+	tokenResp, err := c.postToken(ctx, url, body)
+	if err != nil {
+		return err
+	}
+	if !tokenResp.Success {
+		return newDeyeError("account/token", tokenResp.Code, tokenResp.Msg, DeyeErrUnknown)
+	}
+
+	c.setToken(tokenResp)
+	slog.Info("[deye] Auth OK", "expires", c.expiresAt.Format("2006-01-02 15:04"))
+
+	return nil
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// refreshAccessToken exchanges the stored refresh token for a new access
+// token without sending the account password.
+func (c *DeyeClient) refreshAccessToken(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.refreshToken == "" {
+		return fmt.Errorf("no refresh token")
+	}
+
+	url := fmt.Sprintf("%s/v1.0/account/token/refresh?appId=%s", c.baseURL, c.appID)
+	tokenResp, err := c.postToken(ctx, url, refreshTokenRequest{RefreshToken: c.refreshToken})
+	if err != nil {
+		return err
+	}
+	if !tokenResp.Success {
+		return newDeyeError("account/token/refresh", tokenResp.Code, tokenResp.Msg, DeyeErrToken)
+	}
+
+	c.setToken(tokenResp)
+	slog.Info("[deye] Token refreshed", "expires", c.expiresAt.Format("2006-01-02 15:04"))
+
+	return nil
+}
+
+// reauthenticate obtains a fresh access token, preferring the refresh token
+// and falling back to a full email+password login.
+func (c *DeyeClient) reauthenticate(ctx context.Context) error {
+	if err := c.refreshAccessToken(ctx); err != nil {
+		slog.Warn("[deye] Refresh failed, falling back to full authentication", "err", err)
+		return c.Authenticate(ctx)
+	}
+	return nil
+}
+
+// postToken sends a request to one of the token endpoints. Caller must hold c.mu.
+func (c *DeyeClient) postToken(ctx context.Context, url string, body interface{}) (*tokenResponse, error) {
 	data, err := json.Marshal(body)
 	if err != nil {
-		return fmt.Errorf("marshal token request: %w", err)
+		return nil, fmt.Errorf("marshal token request: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/v1.0/account/token?appId=%s", c.baseURL, c.appID)
-	log.Printf("[deye] >>> POST %s", url)
-	log.Printf("[deye] >>> Body: %s", string(data))
+	slog.Debug("[deye] >>> POST", "url", url)
+	slog.Debug("[deye] >>> Body", "body", redactSecrets(data))
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
 	if err != nil {
-		return fmt.Errorf("create token request: %w", err)
+		return nil, fmt.Errorf("create token request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("token request failed: %w", err)
+		return nil, fmt.Errorf("token request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("read token response: %w", err)
+		return nil, fmt.Errorf("read token response: %w", err)
 	}
 
-	log.Printf("[deye] <<< %d %s", resp.StatusCode, string(respBody))
+	slog.Debug("[deye] <<<", "status", resp.StatusCode, "body", redactSecrets(respBody))
 
 	var tokenResp tokenResponse
 	if err := json.Unmarshal(respBody, &tokenResp); err != nil {
-		return fmt.Errorf("unmarshal token response: %w", err)
-	}
-
-	if !tokenResp.Success {
-		return fmt.Errorf("deye auth failed: code=%s msg=%s", tokenResp.Code, tokenResp.Msg)
+		return nil, fmt.Errorf("unmarshal token response: %w", err)
 	}
+	return &tokenResp, nil
+}
 
+// setToken stores tokens from a successful token response. Caller must hold c.mu.
+func (c *DeyeClient) setToken(tokenResp *tokenResponse) {
 	// Ensure token has "Bearer " prefix
 	token := tokenResp.AccessToken
 	if !strings.HasPrefix(token, "Bearer ") {
 		token = "Bearer " + token
 	}
 	c.accessToken = token
-	// Token expires in ~60 days, refresh 1 hour before
-	c.expiresAt = time.Now().Add(59 * 24 * time.Hour)
+	// Refresh responses may omit a new refresh token; keep the old one then
+	if tokenResp.RefreshToken != "" {
+		c.refreshToken = tokenResp.RefreshToken
+	}
+	ttl, err := parseExpiresIn(tokenResp.ExpiresIn)
+	if err != nil {
+		slog.Warn("[deye] Cannot parse token expiresIn, assuming default",
+			"expiresIn", tokenResp.ExpiresIn, "err", err, "ttl", fallbackTokenTTL)
+		ttl = fallbackTokenTTL
+	} else if ttl > tokenExpiryMargin {
+		ttl -= tokenExpiryMargin
+	}
+	c.expiresAt = time.Now().Add(ttl)
+	c.saveTokenCache()
+}
 
-	log.Printf("[deye] Auth OK, token: %s...%s, expires: %s",
-		c.accessToken[:15], c.accessToken[len(c.accessToken)-6:],
-		c.expiresAt.Format("2006-01-02 15:04"))
+const (
+	// fallbackTokenTTL is used when Deye doesn't report a usable expiresIn
+	// (tokens historically lived ~60 days).
+	fallbackTokenTTL = 59 * 24 * time.Hour
+	// tokenExpiryMargin — refresh this long before the token actually expires
+	tokenExpiryMargin = time.Hour
+)
 
-	return nil
+// parseExpiresIn converts Deye's expiresIn string into a duration. The API
+// returns seconds, but some regions return milliseconds; values too large to
+// be a sane number of seconds (more than ~10 years) are treated as ms.
+func parseExpiresIn(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty value")
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("non-positive value %d", n)
+	}
+	const maxSeconds = 10 * 365 * 24 * 60 * 60
+	if n > maxSeconds {
+		return time.Duration(n) * time.Millisecond, nil
+	}
+	return time.Duration(n) * time.Second, nil
 }
 
-func (c *DeyeClient) getToken() (string, error) {
+func (c *DeyeClient) getToken(ctx context.Context) (string, error) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
 	c.mu.Lock()
 	token := c.accessToken
 	expired := time.Now().After(c.expiresAt)
 	c.mu.Unlock()
 
 	if token == "" || expired {
-		if err := c.Authenticate(); err != nil {
+		if err := c.reauthenticate(ctx); err != nil {
 			return "", err
 		}
 		c.mu.Lock()
@@ -144,11 +363,108 @@ func (c *DeyeClient) getToken() (string, error) {
 	return token, nil
 }
 
-// authErrorCodes — Deye application-level codes that indicate token expiry / auth failure
-var authErrorCodes = map[string]bool{
-	"1000004": true, // token expired
-	"1000003": true, // token invalid
-	"1000002": true, // unauthorized
+// replaceToken re-authenticates after Deye rejected the rejected token.
+// Like getToken it holds authMu, so requests refused at the same time do
+// one refresh: the others find the token already replaced and reuse it.
+func (c *DeyeClient) replaceToken(ctx context.Context, rejected string) error {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	c.mu.Lock()
+	replaced := c.accessToken != "" && c.accessToken != rejected && time.Now().Before(c.expiresAt)
+	c.mu.Unlock()
+	if replaced {
+		return nil
+	}
+	return c.reauthenticate(ctx)
+}
+
+// DeyeErrorKind groups Deye failures by how the caller should react.
+type DeyeErrorKind int
+
+const (
+	// DeyeErrUnknown is an unrecognized code, assumed to be transient
+	DeyeErrUnknown DeyeErrorKind = iota
+	// DeyeErrTemporary — rate limiting or server trouble; retry later
+	DeyeErrTemporary
+	// DeyeErrToken — the access token expired or was revoked; re-authenticate
+	DeyeErrToken
+	// DeyeErrCredentials — login was rejected; retrying won't help until
+	// DEYE_EMAIL, DEYE_PASSWORD or the app credentials are fixed
+	DeyeErrCredentials
+	// DeyeErrRequest — the request itself was rejected, e.g. an unknown station
+	DeyeErrRequest
+)
+
+// deyeErrorCodes maps Deye application-level codes to their kind. Codes not
+// listed fall back to the kind chosen by the caller, DeyeErrUnknown (retried)
+// on every endpoint, so only codes confirmed against a real Deye response
+// belong here: a code wrongly marked as fatal stops polling.
+var deyeErrorCodes = map[string]DeyeErrorKind{
+	"1000004": DeyeErrToken, // token expired
+	"1000003": DeyeErrToken, // token invalid
+	"1000002": DeyeErrToken, // unauthorized
+}
+
+// DeyeError is a failed Deye Cloud request: either success=false with an
+// application code, or an HTTP error status.
+type DeyeError struct {
+	Op         string // API path or operation, e.g. "station/latest"
+	HTTPStatus int    // set for HTTP-level failures
+	Code       string
+	Msg        string
+	Kind       DeyeErrorKind
+}
+
+func (e *DeyeError) Error() string {
+	if e.HTTPStatus != 0 {
+		return fmt.Sprintf("%s failed: HTTP %d", e.Op, e.HTTPStatus)
+	}
+	return fmt.Sprintf("%s failed: code=%s msg=%s", e.Op, e.Code, e.Msg)
+}
+
+// Retryable reports whether the same request may succeed later without
+// changes to the configuration. The poller stops retrying at its usual pace
+// and alerts the admins when it is false.
+func (e *DeyeError) Retryable() bool {
+	return e.Kind == DeyeErrUnknown || e.Kind == DeyeErrTemporary || e.Kind == DeyeErrToken
+}
+
+// newDeyeError classifies an application-level failure by code, using
+// fallback for codes missing from deyeErrorCodes.
+func newDeyeError(op, code, msg string, fallback DeyeErrorKind) *DeyeError {
+	kind, ok := deyeErrorCodes[code]
+	if !ok {
+		kind = fallback
+	}
+	return &DeyeError{Op: op, Code: code, Msg: msg, Kind: kind}
+}
+
+// httpDeyeError classifies an HTTP error status, or returns nil for a
+// successful one.
+func httpDeyeError(op string, status int) *DeyeError {
+	var kind DeyeErrorKind
+	switch {
+	case status < 400:
+		return nil
+	case status == http.StatusTooManyRequests || status >= 500:
+		kind = DeyeErrTemporary
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		kind = DeyeErrToken
+	default:
+		kind = DeyeErrRequest
+	}
+	return &DeyeError{Op: op, HTTPStatus: status, Kind: kind}
+}
+
+// fatalDeyeError returns the DeyeError in err if retrying can't fix it,
+// else nil.
+func fatalDeyeError(err error) *DeyeError {
+	var de *DeyeError
+	if errors.As(err, &de) && !de.Retryable() {
+		return de
+	}
+	return nil
 }
 
 type deyeBaseResponse struct {
@@ -157,12 +473,12 @@ type deyeBaseResponse struct {
 	Msg     string `json:"msg"`
 }
 
-func (c *DeyeClient) doRequest(path string, reqBody interface{}, result interface{}) error {
-	return c.doRequestWithRetry(path, reqBody, result, false)
+func (c *DeyeClient) doRequest(ctx context.Context, path string, reqBody interface{}, result interface{}) error {
+	return c.doRequestWithRetry(ctx, path, reqBody, result, false)
 }
 
-func (c *DeyeClient) doRequestWithRetry(path string, reqBody interface{}, result interface{}, isRetry bool) error {
-	token, err := c.getToken()
+func (c *DeyeClient) doRequestWithRetry(ctx context.Context, path string, reqBody interface{}, result interface{}, isRetry bool) error {
+	token, err := c.getToken(ctx)
 	if err != nil {
 		return fmt.Errorf("get token: %w", err)
 	}
@@ -173,11 +489,11 @@ func (c *DeyeClient) doRequestWithRetry(path string, reqBody interface{}, result
 	}
 
 	url := c.baseURL + path
-	log.Printf("[deye] >>> POST %s", url)
-	log.Printf("[deye] >>> Body: %s", string(data))
-	log.Printf("[deye] >>> Authorization: %s...%s", token[:15], token[len(token)-6:])
+	slog.Debug("[deye] >>> POST", "url", url)
+	slog.Debug("[deye] >>> Body", "body", redactSecrets(data))
+	slog.Debug("[deye] >>> Authorization", "value", "Bearer "+redactedValue)
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
@@ -195,41 +511,39 @@ func (c *DeyeClient) doRequestWithRetry(path string, reqBody interface{}, result
 		return fmt.Errorf("read response: %w", err)
 	}
 
-	log.Printf("[deye] <<< %d %s", resp.StatusCode, string(respBody))
+	slog.Debug("[deye] <<<", "status", resp.StatusCode, "body", redactSecrets(respBody))
 
 	// Check HTTP-level 401
 	if resp.StatusCode == 401 {
 		if isRetry {
-			return fmt.Errorf("unauthorized after re-auth (HTTP 401)")
+			return fmt.Errorf("unauthorized after re-auth: %w", httpDeyeError(path, resp.StatusCode))
 		}
-		log.Printf("[deye] Got HTTP 401, re-authenticating...")
-		c.mu.Lock()
-		c.accessToken = ""
-		c.mu.Unlock()
-		if err := c.Authenticate(); err != nil {
+		slog.Info("[deye] Got HTTP 401, re-authenticating")
+		if err := c.replaceToken(ctx, token); err != nil {
 			return fmt.Errorf("re-auth failed: %w", err)
 		}
-		return c.doRequestWithRetry(path, reqBody, result, true)
+		return c.doRequestWithRetry(ctx, path, reqBody, result, true)
 	}
 
 	// Check application-level auth errors (Deye returns 200 but success=false)
 	if !isRetry {
 		var base deyeBaseResponse
 		if jsonErr := json.Unmarshal(respBody, &base); jsonErr == nil {
-			if !base.Success && authErrorCodes[base.Code] {
-				log.Printf("[deye] Got app-level auth error code=%s msg=%s, re-authenticating...", base.Code, base.Msg)
-				c.mu.Lock()
-				c.accessToken = ""
-				c.mu.Unlock()
-				if err := c.Authenticate(); err != nil {
+			if !base.Success && deyeErrorCodes[base.Code] == DeyeErrToken {
+				slog.Info("[deye] Got app-level auth error, re-authenticating", "code", base.Code, "msg", base.Msg)
+				if err := c.replaceToken(ctx, token); err != nil {
 					return fmt.Errorf("re-auth failed: %w", err)
 				}
-				return c.doRequestWithRetry(path, reqBody, result, true)
+				return c.doRequestWithRetry(ctx, path, reqBody, result, true)
 			}
 		}
 	}
 
 	if err := json.Unmarshal(respBody, result); err != nil {
+		// Error pages from a proxy or an overloaded server aren't JSON
+		if de := httpDeyeError(path, resp.StatusCode); de != nil {
+			return de
+		}
 		return fmt.Errorf("unmarshal response: %w (body: %s)", err, string(respBody))
 	}
 
@@ -262,16 +576,32 @@ type DeviceListResponse struct {
 	Devices []DeviceListItem `json:"deviceListItems"`
 }
 
-func (c *DeyeClient) GetDeviceList() (*DeviceListResponse, error) {
-	reqBody := DeviceListRequest{Page: 1, Size: 100}
-	var resp DeviceListResponse
-	if err := c.doRequest("/v1.0/device/list", reqBody, &resp); err != nil {
-		return nil, err
-	}
-	if !resp.Success {
-		return nil, fmt.Errorf("device/list failed: code=%s msg=%s", resp.Code, resp.Msg)
+// deviceListPageSize is the largest page device/list accepts.
+const deviceListPageSize = 100
+
+// GetDeviceList returns every device on the account, fetching as many pages
+// as the reported total needs.
+func (c *DeyeClient) GetDeviceList(ctx context.Context) (*DeviceListResponse, error) {
+	var all DeviceListResponse
+	for page := 1; ; page++ {
+		reqBody := DeviceListRequest{Page: page, Size: deviceListPageSize}
+		var resp DeviceListResponse
+		if err := c.doRequest(ctx, "/v1.0/device/list", reqBody, &resp); err != nil {
+			return nil, err
+		}
+		if !resp.Success {
+			return nil, newDeyeError("device/list", resp.Code, resp.Msg, DeyeErrUnknown)
+		}
+		if page == 1 {
+			all = resp
+		} else {
+			all.Devices = append(all.Devices, resp.Devices...)
+		}
+		// A short page also ends the loop, in case the total is off
+		if len(all.Devices) >= resp.Total || len(resp.Devices) < deviceListPageSize {
+			return &all, nil
+		}
 	}
-	return &resp, nil
 }
 
 // --- Station Latest ---
@@ -297,14 +627,14 @@ type StationLatestResponse struct {
 	LastUpdateTime   float64  `json:"lastUpdateTime"`
 }
 
-func (c *DeyeClient) GetStationLatest(stationID int64) (*StationLatestResponse, error) {
+func (c *DeyeClient) GetStationLatest(ctx context.Context, stationID int64) (*StationLatestResponse, error) {
 	reqBody := StationLatestRequest{StationID: stationID}
 	var resp StationLatestResponse
-	if err := c.doRequest("/v1.0/station/latest", reqBody, &resp); err != nil {
+	if err := c.doRequest(ctx, "/v1.0/station/latest", reqBody, &resp); err != nil {
 		return nil, err
 	}
 	if !resp.Success {
-		return nil, fmt.Errorf("station/latest failed: code=%s msg=%s", resp.Code, resp.Msg)
+		return nil, newDeyeError("station/latest", resp.Code, resp.Msg, DeyeErrUnknown)
 	}
 	return &resp, nil
 }
@@ -328,6 +658,40 @@ type DeviceLatestEntry struct {
 	DataList       []DeviceDataItem `json:"dataList"`
 }
 
+// Item looks up a data item by name, ignoring case and surrounding spaces.
+func (e DeviceLatestEntry) Item(name string) (DeviceDataItem, bool) {
+	name = strings.TrimSpace(name)
+	for _, item := range e.DataList {
+		if strings.EqualFold(strings.TrimSpace(item.Name), name) {
+			return item, true
+		}
+	}
+	return DeviceDataItem{}, false
+}
+
+// String returns the raw value of the named item.
+func (e DeviceLatestEntry) String(name string) (string, bool) {
+	item, ok := e.Item(name)
+	if !ok {
+		return "", false
+	}
+	return strings.TrimSpace(item.Value), true
+}
+
+// Float returns the numeric value of the named item. Units are not
+// converted; ok is false if the item is missing or not a number.
+func (e DeviceLatestEntry) Float(name string) (float64, bool) {
+	s, ok := e.String(name)
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
 type DeviceLatestResponse struct {
 	Success    bool                `json:"success"`
 	Code       string              `json:"code"`
@@ -335,33 +699,257 @@ type DeviceLatestResponse struct {
 	DeviceList []DeviceLatestEntry `json:"deviceDataList"`
 }
 
-func (c *DeyeClient) GetDeviceLatest(deviceSNs []string) (*DeviceLatestResponse, error) {
+func (c *DeyeClient) GetDeviceLatest(ctx context.Context, deviceSNs []string) (*DeviceLatestResponse, error) {
 	reqBody := DeviceLatestRequest{DeviceList: deviceSNs}
 	var resp DeviceLatestResponse
-	if err := c.doRequest("/v1.0/device/latest", reqBody, &resp); err != nil {
+	if err := c.doRequest(ctx, "/v1.0/device/latest", reqBody, &resp); err != nil {
 		return nil, err
 	}
 	if !resp.Success {
-		return nil, fmt.Errorf("device/latest failed: code=%s msg=%s", resp.Code, resp.Msg)
+		return nil, newDeyeError("device/latest", resp.Code, resp.Msg, DeyeErrUnknown)
 	}
 	return &resp, nil
 }
 
+// --- Device Alarms ---
+
+// deviceAlarmWindow is how far back GetDeviceAlarms looks for alarms that
+// are still active.
+const deviceAlarmWindow = 7 * 24 * time.Hour
+
+type DeviceAlarmsRequest struct {
+	DeviceSn       string `json:"deviceSn"`
+	StartTimestamp int64  `json:"startTimestamp"`
+	EndTimestamp   int64  `json:"endTimestamp"`
+	Page           int    `json:"page"`
+	Size           int    `json:"size"`
+}
+
+// DeviceAlarm is a fault or warning raised by the inverter.
+type DeviceAlarm struct {
+	AlertID        int64     `json:"alertId"`
+	Code           alarmCode `json:"code"`
+	AlertName      string    `json:"alertName"`
+	Description    string    `json:"description"`
+	Level          int       `json:"level"`
+	StartTimestamp int64     `json:"startTimestamp"`
+	EndTimestamp   int64     `json:"endTimestamp"` // 0 while the alarm is active
+}
+
+// alarmCode is a fault code such as "F35". Deye sends it as a string or a
+// bare number depending on the device.
+type alarmCode string
+
+func (c *alarmCode) UnmarshalJSON(data []byte) error {
+	*c = alarmCode(strings.Trim(string(data), `"`))
+	if *c == "null" {
+		*c = ""
+	}
+	return nil
+}
+
+// key normalizes the code to the "F35" form used for descriptions.
+func (c alarmCode) key() string {
+	s := strings.ToUpper(strings.TrimSpace(string(c)))
+	if s != "" && s[0] >= '0' && s[0] <= '9' {
+		s = "F" + strings.TrimLeft(s, "0")
+	}
+	return s
+}
+
+type AlarmResponse struct {
+	Success bool          `json:"success"`
+	Code    string        `json:"code"`
+	Msg     string        `json:"msg"`
+	Total   int           `json:"total"`
+	Alarms  []DeviceAlarm `json:"alertList"`
+}
+
+// Active returns the alarms that haven't ended.
+func (r *AlarmResponse) Active() []DeviceAlarm {
+	var active []DeviceAlarm
+	for _, a := range r.Alarms {
+		if a.EndTimestamp == 0 {
+			active = append(active, a)
+		}
+	}
+	return active
+}
+
+// GetDeviceAlarms returns the device's alarms of the last deviceAlarmWindow.
+func (c *DeyeClient) GetDeviceAlarms(ctx context.Context, deviceSN string) (*AlarmResponse, error) {
+	now := time.Now()
+	reqBody := DeviceAlarmsRequest{
+		DeviceSn:       deviceSN,
+		StartTimestamp: now.Add(-deviceAlarmWindow).Unix(),
+		EndTimestamp:   now.Unix(),
+		Page:           1,
+		Size:           100,
+	}
+	var resp AlarmResponse
+	if err := c.doRequest(ctx, "/v1.0/device/alertList", reqBody, &resp); err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, newDeyeError("device/alertList", resp.Code, resp.Msg, DeyeErrUnknown)
+	}
+	return &resp, nil
+}
+
+// --- Station History ---
+
+// Station history granularities accepted by GetStationHistory
+const (
+	HistoryFrame = "frame" // raw samples, a few minutes apart
+	HistoryDay   = "day"
+	HistoryMonth = "month"
+	HistoryYear  = "year"
+)
+
+// historyGranularities maps granularity names to the API's codes and the
+// longest range one request may cover (in days, 0 = unlimited).
+var historyGranularities = map[string]struct {
+	code      int
+	chunkDays int
+}{
+	HistoryFrame: {1, 1},
+	HistoryDay:   {2, 30},
+	HistoryMonth: {3, 365},
+	HistoryYear:  {4, 0},
+}
+
+type StationHistoryRequest struct {
+	StationID   int64  `json:"stationId"`
+	Granularity int    `json:"granularity"`
+	StartAt     string `json:"startAt"` // yyyy-MM-dd
+	EndAt       string `json:"endAt"`   // yyyy-MM-dd
+}
+
+// StationHistoryItem is one point of station history. Frame items carry
+// instantaneous power (W) and SOC; day/month/year items carry energy
+// totals (kWh) for the period.
+type StationHistoryItem struct {
+	TimeStamp        int64    `json:"timeStamp"` // unix seconds, frame only
+	GenerationPower  *float64 `json:"generationPower"`
+	ConsumptionPower *float64 `json:"consumptionPower"`
+	GridPower        *float64 `json:"gridPower"`
+	PurchasePower    *float64 `json:"purchasePower"`
+	BatteryPower     *float64 `json:"batteryPower"`
+	BatterySOC       *float64 `json:"batterySOC"`
+
+	GenerationValue  float64 `json:"generationValue"`
+	ConsumptionValue float64 `json:"consumptionValue"`
+	GridValue        float64 `json:"gridValue"`
+	PurchaseValue    float64 `json:"purchaseValue"`
+	ChargeValue      float64 `json:"chargeValue"`
+	DischargeValue   float64 `json:"dischargeValue"`
+	Year             int     `json:"year"`
+	Month            int     `json:"month"`
+	Day              int     `json:"day"`
+}
+
+type StationHistoryResponse struct {
+	Success bool   `json:"success"`
+	Code    string `json:"code"`
+	Msg     string `json:"msg"`
+
+	StationDataItems []StationHistoryItem `json:"stationDataItems"`
+}
+
+// GetStationHistory returns the station's history between the dates of start
+// and end (inclusive). Ranges longer than the API allows for the
+// granularity are fetched in chunks and merged.
+func (c *DeyeClient) GetStationHistory(ctx context.Context, stationID int64, start, end time.Time, granularity string) (*StationHistoryResponse, error) {
+	g, ok := historyGranularities[granularity]
+	if !ok {
+		return nil, fmt.Errorf("unknown history granularity %q", granularity)
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("history range ends before it starts")
+	}
+
+	merged := &StationHistoryResponse{Success: true}
+	for chunkStart := start; !chunkStart.After(end); {
+		chunkEnd := end
+		if g.chunkDays > 0 {
+			if e := chunkStart.AddDate(0, 0, g.chunkDays-1); e.Before(end) {
+				chunkEnd = e
+			}
+		}
+
+		reqBody := StationHistoryRequest{
+			StationID:   stationID,
+			Granularity: g.code,
+			StartAt:     chunkStart.Format("2006-01-02"),
+			EndAt:       chunkEnd.Format("2006-01-02"),
+		}
+		var resp StationHistoryResponse
+		if err := c.doRequest(ctx, "/v1.0/station/history", reqBody, &resp); err != nil {
+			return nil, err
+		}
+		if !resp.Success {
+			return nil, newDeyeError("station/history", resp.Code, resp.Msg, DeyeErrUnknown)
+		}
+		merged.StationDataItems = append(merged.StationDataItems, resp.StationDataItems...)
+
+		if g.chunkDays == 0 {
+			break
+		}
+		chunkStart = chunkEnd.AddDate(0, 0, 1)
+	}
+	return merged, nil
+}
+
+// GetStationEnergyDaily returns the station's energy totals for the given day.
+func (c *DeyeClient) GetStationEnergyDaily(ctx context.Context, stationID int64, date time.Time) (*StationHistoryItem, error) {
+	resp, err := c.GetStationHistory(ctx, stationID, date, date, HistoryDay)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.StationDataItems) == 0 {
+		return nil, fmt.Errorf("station/history: no data for %s", date.Format("2006-01-02"))
+	}
+	return &resp.StationDataItems[0], nil
+}
+
+// GetStationEnergyMonthly returns the station's energy totals for the month
+// containing date.
+func (c *DeyeClient) GetStationEnergyMonthly(ctx context.Context, stationID int64, date time.Time) (*StationHistoryItem, error) {
+	first := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+	resp, err := c.GetStationHistory(ctx, stationID, first, first, HistoryMonth)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range resp.StationDataItems {
+		if item.Year == first.Year() && item.Month == int(first.Month()) {
+			return &resp.StationDataItems[i], nil
+		}
+	}
+	return nil, fmt.Errorf("station/history: no data for %s", first.Format("2006-01"))
+}
+
 // --- Power Status ---
 
 type PowerStatus struct {
 	HasGrid          bool
 	GridPower        float64
 	PurchasePower    float64
+	WirePower        *float64 // W on the AC-coupled line, nil if unreported
 	GenerationPower  float64
 	ConsumptionPower float64
 	BatterySOC       float64
 	BatteryPower     float64
 	BatteryTemp      *float64 // °C, nil if unavailable
+	GridVoltage      *float64 // V, nil if unavailable
+	GridFrequency    *float64 // Hz, nil if unavailable
+	ChargePower      float64
 	DischargePower   float64
 	DeviceOnline     bool
 	DeviceState      int
 	LastUpdateTime   float64 // unix timestamp
+	// Alarms are the active alarms while DeviceState is deviceStateAlert,
+	// if the source reports them
+	Alarms []DeviceAlarm
 }
 
 func ptrVal(p *float64) float64 {
@@ -371,42 +959,154 @@ func ptrVal(p *float64) float64 {
 	return *p
 }
 
-func (c *DeyeClient) GetPowerStatus(stationID int64, deviceSN string) (*PowerStatus, error) {
+// GridThresholds tune grid detection: the gridPower sign convention and the
+// fallback used when the station reports neither gridPower nor purchasePower.
+type GridThresholds struct {
+	// GridExportPositive — the inverter reports export to the grid as
+	// positive gridPower instead of import (GRID_POWER_SIGN=export-positive)
+	GridExportPositive bool
+	// MinGridPowerW — wire, grid and purchase power at or below this is
+	// standby noise rather than power from the grid
+	MinGridPowerW float64
+	// FallbackMaxDischargeW — battery discharge at or below this counts as idle
+	FallbackMaxDischargeW float64
+	// FallbackMaxSOCDrop — SOC drop (percentage points) since the previous
+	// poll still considered stable
+	FallbackMaxSOCDrop float64
+}
+
+// gridImport returns gridPower with import from the grid as positive,
+// whichever convention the inverter uses; nil if not reported.
+func (th GridThresholds) gridImport(station *StationLatestResponse) *float64 {
+	if station.GridPower == nil || !th.GridExportPositive {
+		return station.GridPower
+	}
+	v := -*station.GridPower
+	return &v
+}
+
+// detectGrid decides whether the grid is present and explains why, checking
+// in order:
+//   - wirePower > 0 → grid is delivering power (most reliable indicator)
+//   - gridPower importing (> 0, or < 0 with GRID_POWER_SIGN=export-positive)
+//     or purchasePower > 0 → also confirms grid presence
+//   - gridPower and purchasePower both null (some firmwares) → wirePower
+//     decides if reported; otherwise the house consumes power while the
+//     battery neither discharges nor loses SOC, so something else must be
+//     feeding it
+//
+// "> 0" above means above GRID_POWER_THRESHOLD_W, which defaults to 0.
+func detectGrid(station *StationLatestResponse, prevSOC *float64, th GridThresholds) (bool, string) {
+	if w := ptrVal(station.WirePower); w > th.MinGridPowerW {
+		return true, fmt.Sprintf("wirePower=%.0fW", w)
+	}
+	if g := ptrVal(th.gridImport(station)); g > th.MinGridPowerW {
+		return true, fmt.Sprintf("gridPower=%.0fW", g)
+	}
+	if p := ptrVal(station.PurchasePower); p > th.MinGridPowerW {
+		return true, fmt.Sprintf("purchasePower=%.0fW", p)
+	}
+	if station.GridPower != nil || station.PurchasePower != nil {
+		return false, "no grid/purchase power"
+	}
+	if station.WirePower != nil {
+		return false, "grid/purchase null, no wire power"
+	}
+
+	consumption := ptrVal(station.ConsumptionPower)
+	discharge := ptrVal(station.DischargePower)
+	if consumption <= 0 {
+		return false, "fallback: grid/purchase null, no consumption"
+	}
+	if discharge > th.FallbackMaxDischargeW {
+		return false, fmt.Sprintf("fallback: grid/purchase null, battery discharging %.0fW", discharge)
+	}
+	if prevSOC != nil && station.BatterySOC != nil && *prevSOC-*station.BatterySOC > th.FallbackMaxSOCDrop {
+		return false, fmt.Sprintf("fallback: grid/purchase null, SOC dropping %.0f%% → %.0f%%", *prevSOC, *station.BatterySOC)
+	}
+	return true, fmt.Sprintf("fallback: grid/purchase null, consumption=%.0fW with battery discharge=%.0fW", consumption, discharge)
+}
+
+func (c *DeyeClient) GetPowerStatus(ctx context.Context, stationID int64, deviceSN string) (*PowerStatus, error) {
+	cacheKey := fmt.Sprintf("%d:%s", stationID, deviceSN)
+
 	c.mu.Lock()
-	if c.cachedStatus != nil && time.Now().Before(c.cacheExpireAt) {
-		cached := c.cachedStatus
+	if cached, ok := c.statusCache[cacheKey]; ok && time.Now().Before(cached.expireAt) {
 		c.mu.Unlock()
-		return cached, nil
+		return cached.status, nil
 	}
 	c.mu.Unlock()
 
-	station, err := c.GetStationLatest(stationID)
-	if err != nil {
-		return nil, fmt.Errorf("get station: %w", err)
+	// The two requests are independent; a failure of one cancels the other
+	var (
+		station *StationLatestResponse
+		device  *DeviceLatestResponse
+	)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		if station, err = c.GetStationLatest(gctx, stationID); err != nil {
+			return fmt.Errorf("get station: %w", err)
+		}
+		return nil
+	})
+	g.Go(func() error {
+		var err error
+		if device, err = c.GetDeviceLatest(gctx, []string{deviceSN}); err != nil {
+			return fmt.Errorf("get device: %w", err)
+		}
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
 
-	device, err := c.GetDeviceLatest([]string{deviceSN})
-	if err != nil {
-		return nil, fmt.Errorf("get device: %w", err)
+	// The previous reading (even if expired) tells whether SOC is dropping
+	var prevSOC *float64
+	c.mu.Lock()
+	if prev, ok := c.statusCache[cacheKey]; ok {
+		soc := prev.status.BatterySOC
+		prevSOC = &soc
 	}
+	c.mu.Unlock()
 
-	gridPower := ptrVal(station.GridPower)
-	purchasePower := ptrVal(station.PurchasePower)
+	status, reason := buildPowerStatus(station, device, prevSOC, c.gridThresholds)
+	slog.Debug("[deye] Grid detection", "station", stationID, "hasGrid", status.HasGrid, "reason", reason)
 
-	// Determine if grid is available:
-	// - wirePower > 0 → grid is delivering power (most reliable indicator)
-	// - gridPower > 0 or purchasePower > 0 → also confirms grid presence
-	wirePower := ptrVal(station.WirePower)
-	hasGrid := wirePower > 0 || gridPower > 0 || purchasePower > 0
+	// The details are worth the extra request only while there is an alarm
+	if status.DeviceState == deviceStateAlert {
+		if alarms, err := c.GetDeviceAlarms(ctx, deviceSN); err != nil {
+			slog.Warn("[deye] Failed to get device alarms", "sn", deviceSN, "err", err)
+		} else {
+			status.Alarms = alarms.Active()
+		}
+	}
+
+	c.mu.Lock()
+	c.statusCache[cacheKey] = cachedPowerStatus{
+		status:   status,
+		expireAt: time.Now().Add(c.statusCacheTTL),
+	}
+	c.mu.Unlock()
+
+	return status, nil
+}
 
+// buildPowerStatus combines the station/latest and device/latest responses
+// and explains the grid decision. prevSOC is the previous reading's SOC, nil
+// if there is none.
+func buildPowerStatus(station *StationLatestResponse, device *DeviceLatestResponse, prevSOC *float64, th GridThresholds) (*PowerStatus, string) {
+	hasGrid, reason := detectGrid(station, prevSOC, th)
 	status := &PowerStatus{
 		HasGrid:          hasGrid,
-		GridPower:        gridPower,
-		PurchasePower:    purchasePower,
+		GridPower:        ptrVal(th.gridImport(station)),
+		PurchasePower:    ptrVal(station.PurchasePower),
+		WirePower:        station.WirePower,
 		GenerationPower:  ptrVal(station.GenerationPower),
 		ConsumptionPower: ptrVal(station.ConsumptionPower),
 		BatterySOC:       ptrVal(station.BatterySOC),
 		BatteryPower:     ptrVal(station.BatteryPower),
+		ChargePower:      ptrVal(station.ChargePower),
 		DischargePower:   ptrVal(station.DischargePower),
 		LastUpdateTime:   station.LastUpdateTime,
 	}
@@ -415,20 +1115,54 @@ func (c *DeyeClient) GetPowerStatus(stationID int64, deviceSN string) (*PowerSta
 		dev := device.DeviceList[0]
 		status.DeviceOnline = dev.DeviceState == 1
 		status.DeviceState = dev.DeviceState
-		for _, item := range dev.DataList {
-			if item.Name == "Temperature- Battery" {
-				var temp float64
-				fmt.Sscanf(item.Value, "%f", &temp)
-				status.BatteryTemp = &temp
-				break
-			}
+		status.BatteryTemp = batteryTemperature(dev.DataList)
+		status.GridVoltage = dev.firstFloat(gridVoltageKeys...)
+		status.GridFrequency = dev.firstFloat(gridFrequencyKeys...)
+	}
+	return status, reason
+}
+
+// Device data key names differ between inverter models; the first one
+// present is used.
+var (
+	gridVoltageKeys   = []string{"Grid Voltage L1-L2", "Grid Voltage L1", "AC Voltage R/U/A", "GridVoltage"}
+	gridFrequencyKeys = []string{"Grid Frequency", "AC Output Frequency R", "GridFrequency"}
+)
+
+// firstFloat returns the value of the first of names present in the entry.
+func (e DeviceLatestEntry) firstFloat(names ...string) *float64 {
+	for _, name := range names {
+		if v, ok := e.Float(name); ok {
+			return &v
 		}
 	}
+	return nil
+}
 
-	c.mu.Lock()
-	c.cachedStatus = status
-	c.cacheExpireAt = time.Now().Add(time.Minute)
-	c.mu.Unlock()
+// batteryTemperature finds the battery temperature among device data items
+// and returns it in °C, or nil if absent or unparsable. Key names differ
+// between inverter models and account languages ("Temperature- Battery",
+// "Battery Temperature", "电池温度"), so any item mentioning both the battery
+// and a temperature matches.
+func batteryTemperature(items []DeviceDataItem) *float64 {
+	for _, item := range items {
+		name := strings.ToLower(item.Name)
+		isBattery := strings.Contains(name, "battery") || strings.Contains(name, "电池")
+		isTemp := strings.Contains(name, "temp") || strings.Contains(name, "温度")
+		if !isBattery || !isTemp {
+			continue
+		}
 
-	return status, nil
+		temp, err := strconv.ParseFloat(strings.TrimSpace(item.Value), 64)
+		if err != nil {
+			slog.Warn("[deye] Unparsable battery temperature", "key", item.Name, "value", item.Value)
+			continue
+		}
+		switch strings.ToUpper(strings.TrimSpace(item.Unit)) {
+		case "F", "°F", "℉":
+			temp = (temp - 32) * 5 / 9
+		}
+		return &temp
+	}
+	return nil
 }
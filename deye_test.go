@@ -0,0 +1,495 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func fp(v float64) *float64 { return &v }
+
+func TestDetectGrid(t *testing.T) {
+	th := GridThresholds{FallbackMaxDischargeW: 20, FallbackMaxSOCDrop: 1}
+
+	tests := []struct {
+		name    string
+		station StationLatestResponse
+		prevSOC *float64
+		export  bool    // GRID_POWER_SIGN=export-positive
+		minW    float64 // GRID_POWER_THRESHOLD_W
+		want    bool
+	}{
+		{
+			name:    "grid power",
+			station: StationLatestResponse{GridPower: fp(850), PurchasePower: fp(0)},
+			want:    true,
+		},
+		{
+			name:    "purchase power",
+			station: StationLatestResponse{GridPower: fp(0), PurchasePower: fp(420)},
+			want:    true,
+		},
+		{
+			name:    "wire power",
+			station: StationLatestResponse{WirePower: fp(300)},
+			want:    true,
+		},
+		{
+			name:    "both zero",
+			station: StationLatestResponse{GridPower: fp(0), PurchasePower: fp(0), ConsumptionPower: fp(500)},
+			want:    false,
+		},
+		{
+			// Only import counts; export alone doesn't confirm the grid
+			name:    "negative grid power",
+			station: StationLatestResponse{GridPower: fp(-1200), PurchasePower: fp(0), ConsumptionPower: fp(500)},
+			want:    false,
+		},
+		{
+			name:    "export-positive, importing",
+			station: StationLatestResponse{GridPower: fp(-850), PurchasePower: fp(0), ConsumptionPower: fp(500)},
+			export:  true,
+			want:    true,
+		},
+		{
+			name:    "export-positive, exporting",
+			station: StationLatestResponse{GridPower: fp(1200), PurchasePower: fp(0), ConsumptionPower: fp(500)},
+			export:  true,
+			want:    false,
+		},
+		{
+			name:    "both null, wire power zero",
+			station: StationLatestResponse{WirePower: fp(0), ConsumptionPower: fp(600), DischargePower: fp(0), BatterySOC: fp(80)},
+			prevSOC: fp(80),
+			want:    false,
+		},
+		{
+			name:    "both null, no consumption",
+			station: StationLatestResponse{ConsumptionPower: fp(0)},
+			want:    false,
+		},
+		{
+			name:    "both null, battery idle",
+			station: StationLatestResponse{ConsumptionPower: fp(600), DischargePower: fp(10), BatterySOC: fp(80)},
+			prevSOC: fp(80),
+			want:    true,
+		},
+		{
+			name:    "both null, battery discharging",
+			station: StationLatestResponse{ConsumptionPower: fp(600), DischargePower: fp(550), BatterySOC: fp(80)},
+			prevSOC: fp(81),
+			want:    false,
+		},
+		{
+			name:    "both null, SOC dropping",
+			station: StationLatestResponse{ConsumptionPower: fp(600), DischargePower: fp(0), BatterySOC: fp(75)},
+			prevSOC: fp(80),
+			want:    false,
+		},
+		{
+			name:    "standby noise below threshold",
+			station: StationLatestResponse{GridPower: fp(12), PurchasePower: fp(8), WirePower: fp(15)},
+			minW:    15,
+			want:    false,
+		},
+		{
+			name:    "import above threshold",
+			station: StationLatestResponse{GridPower: fp(400)},
+			minW:    15,
+			want:    true,
+		},
+		{
+			name:    "both null, no previous SOC",
+			station: StationLatestResponse{ConsumptionPower: fp(600), DischargePower: fp(0), BatterySOC: fp(75)},
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := th
+			th.GridExportPositive = tt.export
+			th.MinGridPowerW = tt.minW
+			got, reason := detectGrid(&tt.station, tt.prevSOC, th)
+			if got != tt.want {
+				t.Errorf("detectGrid() = %v (%s), want %v", got, reason, tt.want)
+			}
+		})
+	}
+}
+
+// fakeDeye is a minimal Deye Cloud API. Handlers for data endpoints are set
+// per test; the token endpoints are built in and counted.
+type fakeDeye struct {
+	t         *testing.T
+	srv       *httptest.Server
+	mu        sync.Mutex
+	logins    int
+	refreshes int
+	token     string // issued by the next login or refresh
+	handlers  map[string]http.HandlerFunc
+}
+
+func newFakeDeye(t *testing.T) *fakeDeye {
+	f := &fakeDeye{t: t, token: "token-1", handlers: make(map[string]http.HandlerFunc)}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeDeye) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	switch r.URL.Path {
+	case "/v1.0/account/token":
+		f.logins++
+	case "/v1.0/account/token/refresh":
+		f.refreshes++
+	default:
+		h := f.handlers[r.URL.Path]
+		f.mu.Unlock()
+		if h == nil {
+			f.t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		h(w, r)
+		return
+	}
+	token := f.token
+	f.mu.Unlock()
+
+	fmt.Fprintf(w, `{"success":true,"code":"1000000","accessToken":%q,"refreshToken":"refresh","expiresIn":"5183999"}`, token)
+}
+
+// tokenRequests counts logins and refreshes so far.
+func (f *fakeDeye) tokenRequests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logins + f.refreshes
+}
+
+func (f *fakeDeye) client() *DeyeClient {
+	return NewDeyeClient(&Config{
+		DeyeBaseURL:   f.srv.URL,
+		DeyeAppID:     "app",
+		DeyeAppSecret: "secret",
+		DeyeEmail:     "user@example.com",
+		DeyePassword:  "password",
+	})
+}
+
+func TestDeyeAuthenticateAddsBearerPrefix(t *testing.T) {
+	f := newFakeDeye(t)
+	var gotAuth string
+	f.handlers["/v1.0/station/latest"] = func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		fmt.Fprint(w, `{"success":true}`)
+	}
+
+	c := f.client()
+	if err := c.Authenticate(t.Context()); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if !c.HasValidToken() {
+		t.Fatal("HasValidToken() = false after Authenticate")
+	}
+	if _, err := c.GetStationLatest(t.Context(), 1); err != nil {
+		t.Fatalf("GetStationLatest: %v", err)
+	}
+	if gotAuth != "Bearer token-1" {
+		t.Errorf("Authorization = %q, want %q", gotAuth, "Bearer token-1")
+	}
+}
+
+func TestDeyeReauthenticatesOnceOn401(t *testing.T) {
+	f := newFakeDeye(t)
+	f.handlers["/v1.0/station/latest"] = func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"batterySOC":55}`)
+	}
+
+	c := f.client()
+	if err := c.Authenticate(t.Context()); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	f.mu.Lock()
+	f.token = "token-2"
+	f.mu.Unlock()
+
+	resp, err := c.GetStationLatest(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetStationLatest: %v", err)
+	}
+	if got := ptrVal(resp.BatterySOC); got != 55 {
+		t.Errorf("BatterySOC = %v, want 55", got)
+	}
+	if n := f.tokenRequests(); n != 2 {
+		t.Errorf("token requests = %d, want 2 (login and one re-auth)", n)
+	}
+}
+
+func TestDeyeConcurrent401RefreshesOnce(t *testing.T) {
+	f := newFakeDeye(t)
+	var rejected sync.WaitGroup
+	rejected.Add(2)
+	f.handlers["/v1.0/station/latest"] = func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			// Both requests are refused before either re-authenticates
+			rejected.Done()
+			rejected.Wait()
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"success":true}`)
+	}
+
+	c := f.client()
+	if err := c.Authenticate(t.Context()); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	f.mu.Lock()
+	f.token = "token-2"
+	f.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() {
+			if _, err := c.GetStationLatest(t.Context(), 1); err != nil {
+				t.Errorf("GetStationLatest: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+	if n := f.tokenRequests(); n != 2 {
+		t.Errorf("token requests = %d, want 2 (login and one re-auth)", n)
+	}
+}
+
+func TestDeyeGives401UpAfterOneReauth(t *testing.T) {
+	f := newFakeDeye(t)
+	var calls atomic.Int32
+	f.handlers["/v1.0/station/latest"] = func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	c := f.client()
+	if err := c.Authenticate(t.Context()); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if _, err := c.GetStationLatest(t.Context(), 1); err == nil {
+		t.Fatal("GetStationLatest succeeded, want error")
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("station/latest called %d times, want 2", n)
+	}
+	if n := f.tokenRequests(); n != 2 {
+		t.Errorf("token requests = %d, want 2 (login and one re-auth)", n)
+	}
+}
+
+func TestDeyeErrorKinds(t *testing.T) {
+	f := newFakeDeye(t)
+	f.handlers["/v1.0/station/latest"] = func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "<html>Service Unavailable</html>")
+	}
+	f.handlers["/v1.0/device/latest"] = func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"success":false,"code":"9999999","msg":"something new"}`)
+	}
+
+	c := f.client()
+	var de *DeyeError
+	_, err := c.GetStationLatest(t.Context(), 1)
+	if !errors.As(err, &de) || de.HTTPStatus != http.StatusServiceUnavailable || !de.Retryable() {
+		t.Errorf("GetStationLatest error = %v, want retryable HTTP 503 DeyeError", err)
+	}
+	// Unlisted codes are retried rather than stopping the poller
+	_, err = c.GetDeviceLatest(t.Context(), []string{"SN"})
+	if !errors.As(err, &de) || de.Code != "9999999" || !de.Retryable() || fatalDeyeError(err) != nil {
+		t.Errorf("GetDeviceLatest error = %v, want retryable DeyeError with code 9999999", err)
+	}
+	if de := newDeyeError("account/token", "9999999", "", DeyeErrUnknown); !de.Retryable() {
+		t.Errorf("unlisted login code: Kind = %v, want retryable", de.Kind)
+	}
+}
+
+func TestGetStationLatestNullFields(t *testing.T) {
+	f := newFakeDeye(t)
+	f.handlers["/v1.0/station/latest"] = func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"success": true,
+			"generationPower": 1520.5,
+			"consumptionPower": 0,
+			"gridPower": null,
+			"batterySOC": 87,
+			"lastUpdateTime": 1718000000
+		}`)
+	}
+
+	resp, err := f.client().GetStationLatest(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("GetStationLatest: %v", err)
+	}
+	if resp.GenerationPower == nil || *resp.GenerationPower != 1520.5 {
+		t.Errorf("GenerationPower = %v, want 1520.5", resp.GenerationPower)
+	}
+	if resp.ConsumptionPower == nil || *resp.ConsumptionPower != 0 {
+		t.Errorf("ConsumptionPower = %v, want non-nil 0", resp.ConsumptionPower)
+	}
+	if resp.GridPower != nil {
+		t.Errorf("GridPower = %v, want nil for null", *resp.GridPower)
+	}
+	if resp.PurchasePower != nil {
+		t.Errorf("PurchasePower = %v, want nil when missing", *resp.PurchasePower)
+	}
+	if resp.LastUpdateTime != 1718000000 {
+		t.Errorf("LastUpdateTime = %v, want 1718000000", resp.LastUpdateTime)
+	}
+}
+
+func TestGetPowerStatusDeviceState(t *testing.T) {
+	f := newFakeDeye(t)
+	f.handlers["/v1.0/station/latest"] = func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"success":true,"gridPower":0,"purchasePower":0}`)
+	}
+	f.handlers["/v1.0/device/latest"] = func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"success": true,
+			"deviceDataList": [{
+				"deviceSn": "SN1",
+				"deviceState": 2,
+				"dataList": [{"key": "Grid Voltage L1", "value": "229.4", "unit": "V"}]
+			}]
+		}`)
+	}
+	// Codes come as strings or numbers; only alarms without an end are active
+	f.handlers["/v1.0/device/alertList"] = func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"success": true,
+			"alertList": [
+				{"code": "F35", "alertName": "No AC grid", "startTimestamp": 1718000000},
+				{"code": 58, "alertName": "BMS comm fault", "startTimestamp": 1718000100},
+				{"code": "F64", "startTimestamp": 1717000000, "endTimestamp": 1717003600}
+			]
+		}`)
+	}
+
+	status, err := f.client().GetPowerStatus(t.Context(), 1, "SN1")
+	if err != nil {
+		t.Fatalf("GetPowerStatus: %v", err)
+	}
+	if status.DeviceState != 2 {
+		t.Errorf("DeviceState = %d, want 2", status.DeviceState)
+	}
+	if status.DeviceOnline {
+		t.Error("DeviceOnline = true for the alert state")
+	}
+	if status.GridVoltage == nil || *status.GridVoltage != 229.4 {
+		t.Errorf("GridVoltage = %v, want 229.4", status.GridVoltage)
+	}
+	want := "⚠️ F35 — немає мережі змінного струму\n⚠️ F58 — немає зв'язку з BMS батареї"
+	if got := formatAlarms(LangUK, status.Alarms); got != want {
+		t.Errorf("alarms = %q, want %q", got, want)
+	}
+}
+
+func TestPinnedTLSConfig(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	cert := srv.Certificate()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	good := "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+	bad := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	tests := []struct {
+		name    string
+		pins    []string
+		wantErr bool
+	}{
+		{"ca only", nil, false},
+		{"matching pin", []string{bad, good}, false},
+		{"wrong pin", []string{bad}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := pinnedTLSConfig(caPEM, tt.pins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := newTLSHTTPClient(5*time.Second, nil, cfg).Get(srv.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := pinnedTLSConfig(nil, []string{"not-a-hash"}); err == nil {
+		t.Error("malformed pin accepted")
+	}
+}
+
+func TestGetDeviceListPages(t *testing.T) {
+	const total = 2*deviceListPageSize + 5
+	f := newFakeDeye(t)
+	var pages atomic.Int32
+	f.handlers["/v1.0/device/list"] = func(w http.ResponseWriter, r *http.Request) {
+		var req DeviceListRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		pages.Add(1)
+		resp := DeviceListResponse{Success: true, Total: total}
+		for i := (req.Page - 1) * req.Size; i < min(req.Page*req.Size, total); i++ {
+			resp.Devices = append(resp.Devices, DeviceListItem{DeviceSn: strconv.Itoa(i)})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+
+	resp, err := f.client().GetDeviceList(t.Context())
+	if err != nil {
+		t.Fatalf("GetDeviceList: %v", err)
+	}
+	if len(resp.Devices) != total {
+		t.Errorf("got %d devices, want %d", len(resp.Devices), total)
+	}
+	if got := pages.Load(); got != 3 {
+		t.Errorf("requested %d pages, want 3", got)
+	}
+	if last := resp.Devices[len(resp.Devices)-1].DeviceSn; last != strconv.Itoa(total-1) {
+		t.Errorf("last device = %s, want %d", last, total-1)
+	}
+}
+
+func TestStatusCacheTTL(t *testing.T) {
+	for _, tt := range []struct {
+		cfg  Config
+		want time.Duration
+	}{
+		{Config{PollIntervalSec: 60}, 30 * time.Second},
+		{Config{PollIntervalSec: 300}, time.Minute},
+		{Config{PollIntervalSec: 300, PollSchedule: []PollWindow{{IntervalSec: 30}}}, 15 * time.Second},
+	} {
+		if got := statusCacheTTL(&tt.cfg); got != tt.want {
+			t.Errorf("statusCacheTTL(%d, %v) = %v, want %v", tt.cfg.PollIntervalSec, tt.cfg.PollSchedule, got, tt.want)
+		}
+	}
+}
@@ -1,49 +1,189 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
 	"time"
+	_ "time/tzdata"
 
 	"os/exec"
 
 	"github.com/go-rod/rod"
 	"github.com/go-rod/rod/lib/launcher"
 	"github.com/go-rod/rod/lib/proto"
+	"golang.org/x/sync/singleflight"
 )
 
-type DtekClient struct {
-	city   string
-	street string
-	house  string
-
+// DtekProvider scrapes the outage schedule from a DTEK subsidiary's site.
+// All of them share the same AJAX API and differ only in baseURL.
+type DtekProvider struct {
+	baseURL  string // e.g. https://www.dtek-dnem.com.ua
+	city     string
+	street   string
+	house    string
+	attempts int
+	maxStale time.Duration // how long the last good value may stand in for a failed fetch
+	cacheTTL time.Duration
+
+	httpClient *http.Client
+	proxy      string // Chromium --proxy-server, empty for a direct connection
+
+	// mu guards the cache and breaker; it is not held while fetching
 	mu          sync.Mutex
 	cachedAt    time.Time
-	cachedValue *DtekShutdown
+	cachedValue *Shutdown
 	cacheHit    bool
+	fetching    bool // a fetch is running
+	breaker     circuitBreaker
+	fetches     singleflight.Group
+
+	// Chromium is launched on first use and kept for later fetches
+	browserMu sync.Mutex
+	launcher  *launcher.Launcher
+	browser   *rod.Browser
 }
 
-type DtekShutdown struct {
+// Shutdown is a scheduled outage for the configured address.
+type Shutdown struct {
 	SubType   string   `json:"sub_type"`
 	StartDate string   `json:"start_date"`
 	EndDate   string   `json:"end_date"`
 	Type      string   `json:"type"`
 	Reason    []string `json:"sub_type_reason"`
+
+	// Start and End are StartDate/EndDate parsed in Kyiv time; zero if
+	// DTEK sent something unparsable
+	Start time.Time `json:"-"`
+	End   time.Time `json:"-"`
+
+	// Stale is set when fetching failed and an older result is served
+	Stale bool `json:"-"`
+}
+
+// kyivLocation is DTEK's time zone. The tzdata import keeps it available
+// on hosts without a zoneinfo database.
+var kyivLocation = mustLoadLocation("Europe/Kyiv")
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// dtekDateLayouts are the formats DTEK uses for outage dates
+var dtekDateLayouts = []string{"02.01.2006 15:04", "15:04 02.01.2006"}
+
+func parseDtekDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dtekDateLayouts {
+		if t, err := time.ParseInLocation(layout, s, kyivLocation); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized DTEK date %q", s)
+}
+
+// parseDates fills Start and End from the raw date strings.
+func (s *Shutdown) parseDates() {
+	var err error
+	if s.Start, err = parseDtekDate(s.StartDate); err != nil {
+		slog.Warn("[dtek] Cannot parse start date", "err", err)
+	}
+	if s.End, err = parseDtekDate(s.EndDate); err != nil {
+		slog.Warn("[dtek] Cannot parse end date", "err", err)
+	}
+}
+
+// Period returns the start and end of the outage in the local time zone
+// (TIMEZONE), falling back to DTEK's own strings for dates it couldn't
+// parse.
+func (s *Shutdown) Period() (start, end string) {
+	start, end = s.StartDate, s.EndDate
+	if !s.Start.IsZero() {
+		start = s.Start.In(time.Local).Format(dtekDateLayouts[0])
+	}
+	if !s.End.IsZero() {
+		end = s.End.In(time.Local).Format(dtekDateLayouts[0])
+	}
+	return start, end
+}
+
+// Countdown describes when the outage starts or ends relative to now,
+// e.g. "закінчиться через 2 год 15 хв". Empty if the dates are unknown or
+// the outage is over.
+func (s *Shutdown) Countdown(l Lang, now time.Time) string {
+	switch {
+	case !s.Start.IsZero() && now.Before(s.Start):
+		return l.tr("dtek.starts_in", formatDuration(l, s.Start.Sub(now)))
+	case !s.End.IsZero() && now.Before(s.End):
+		return l.tr("dtek.ends_in", formatDuration(l, s.End.Sub(now)))
+	}
+	return ""
+}
+
+// dtekShutdownTypes are the known Shutdown.Type codes; their names are
+// the "dtek.type.<code>" messages
+var dtekShutdownTypes = map[string]bool{"1": true, "2": true, "3": true}
+
+// dtekSubTypes are the known Shutdown.SubType codes, described by the
+// "dtek.subtype.<code>" messages; DTEK usually sends the description itself.
+var dtekSubTypes = map[string]bool{"GPV": true, "SGAV": true}
+
+// Kind is the name of the outage type, e.g. "аварійне", or "" when the
+// type is unknown. Stabilization outages are often reported with a generic
+// type and only named in SubType (which DTEK always sends in Ukrainian).
+func (s *Shutdown) Kind(l Lang) string {
+	if strings.Contains(strings.ToLower(s.SubType), "стабілізац") {
+		return l.tr("dtek.type.3")
+	}
+	if code := strings.TrimSpace(s.Type); dtekShutdownTypes[code] {
+		return l.tr("dtek.type." + code)
+	}
+	return ""
+}
+
+// Description is the human-readable sub type.
+func (s *Shutdown) Description(l Lang) string {
+	sub := strings.TrimSpace(s.SubType)
+	if dtekSubTypes[sub] {
+		return l.tr("dtek.subtype." + sub)
+	}
+	return sub
 }
 
 type DtekResponse struct {
-	Result bool                    `json:"result"`
-	Data   map[string]DtekShutdown `json:"data"`
+	Result bool                `json:"result"`
+	Data   map[string]Shutdown `json:"data"`
 }
 
-func NewDtekClient(city, street, house string) *DtekClient {
-	return &DtekClient{city: city, street: street, house: house}
+func NewDtekProvider(cfg *Config, baseURL string) *DtekProvider {
+	attempts := cfg.DtekFetchAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &DtekProvider{
+		baseURL:  baseURL,
+		city:     cfg.DtekCity,
+		street:   cfg.DtekStreet,
+		house:    cfg.DtekHouse,
+		attempts: attempts,
+		maxStale: cfg.DtekMaxStale,
+		cacheTTL: cfg.DtekCacheTTL,
+		breaker:  circuitBreaker{threshold: cfg.DtekBreakerFailures, cooldown: cfg.DtekBreakerCooldown},
+
+		httpClient: newHTTPClient(30*time.Second, cfg.ProxyURL),
+		proxy:      browserProxy(cfg.ProxyURL),
+	}
 }
 
 func lookupBrowser() string {
@@ -67,40 +207,144 @@ func lookupBrowser() string {
 	return ""
 }
 
-func (d *DtekClient) FetchShutdowns() (*DtekShutdown, error) {
+const (
+	dtekRetryBaseDelay = 2 * time.Second
+	// dtekLoadTimeout bounds the wait for the shutdowns page to load
+	dtekLoadTimeout = time.Minute
+	// dtekChallengeTimeout bounds the wait for the Imperva challenge to clear
+	dtekChallengeTimeout = 30 * time.Second
+)
+
+// FetchShutdowns scrapes the schedule of the configured house.
+func (d *DtekProvider) FetchShutdowns(ctx context.Context) (*Shutdown, error) {
+	shutdowns, err := d.FetchShutdownsForHouses(ctx, []string{d.house})
+	if err != nil {
+		return nil, err
+	}
+	return shutdowns[d.house], nil
+}
+
+// FetchShutdownsForHouses scrapes the schedule of several houses on the
+// configured street with a single request, since DTEK answers for the whole
+// street anyway. The map has an entry for every house, nil for houses with
+// no outage scheduled. Fetching is retried with exponential backoff since
+// the Imperva challenge often fails on the first try; cancelling ctx stops
+// the retries.
+func (d *DtekProvider) FetchShutdownsForHouses(ctx context.Context, houses []string) (map[string]*Shutdown, error) {
+	var lastErr error
+	delay := dtekRetryBaseDelay
+	for attempt := 1; attempt <= d.attempts; attempt++ {
+		shutdowns, err := d.fetchShutdownsOnce(ctx, houses)
+		if err == nil {
+			return shutdowns, nil
+		}
+		lastErr = err
+		slog.Warn("[dtek] Attempt failed", "attempt", attempt, "of", d.attempts, "err", err)
+		if attempt < d.attempts {
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("gave up after %d attempts: %w", attempt, lastErr)
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+	}
+	return nil, fmt.Errorf("all %d attempts failed: %w", d.attempts, lastErr)
+}
+
+// getBrowser returns the running browser, launching Chromium if there is
+// none yet or the previous one died.
+func (d *DtekProvider) getBrowser() (*rod.Browser, error) {
+	d.browserMu.Lock()
+	defer d.browserMu.Unlock()
+
+	if d.browser != nil {
+		if _, err := d.browser.Version(); err == nil {
+			return d.browser, nil
+		}
+		slog.Warn("[dtek] Browser is gone, relaunching")
+		d.closeBrowser()
+	}
+
 	browserPath := lookupBrowser()
 	if browserPath == "" {
 		return nil, fmt.Errorf("chromium not found; install it: snap install chromium")
 	}
-	log.Printf("[dtek] Using browser: %s", browserPath)
+	slog.Debug("[dtek] Launching browser", "path", browserPath)
 
-	u, err := launcher.New().
+	l := launcher.New().
 		Bin(browserPath).
 		Headless(true).
 		Set("no-sandbox").
-		Set("disable-gpu").
-		Launch()
+		Set("disable-gpu")
+	if d.proxy != "" {
+		l = l.Proxy(d.proxy)
+	}
+	u, err := l.Launch()
 	if err != nil {
 		return nil, fmt.Errorf("launcher: %w", err)
 	}
 
 	browser := rod.New().ControlURL(u)
 	if err := browser.Connect(); err != nil {
+		l.Kill()
 		return nil, fmt.Errorf("browser connect: %w", err)
 	}
-	defer browser.MustClose()
 
-	page, err := browser.Page(proto.TargetCreateTarget{URL: "https://www.dtek-dnem.com.ua/ua/shutdowns"})
+	d.launcher, d.browser = l, browser
+	return browser, nil
+}
+
+// closeBrowser tears down the browser. Caller must hold d.browserMu.
+func (d *DtekProvider) closeBrowser() {
+	if d.browser != nil {
+		if err := d.browser.Close(); err != nil {
+			slog.Warn("[dtek] Failed to close browser", "err", err)
+		}
+		d.browser = nil
+	}
+	if d.launcher != nil {
+		d.launcher.Kill()
+		d.launcher.Cleanup()
+		d.launcher = nil
+	}
+}
+
+// Close shuts down Chromium. The client relaunches it if used again.
+func (d *DtekProvider) Close() {
+	d.browserMu.Lock()
+	defer d.browserMu.Unlock()
+	d.closeBrowser()
+}
+
+func (d *DtekProvider) fetchShutdownsOnce(ctx context.Context, houses []string) (map[string]*Shutdown, error) {
+	browser, err := d.getBrowser()
+	if err != nil {
+		return nil, err
+	}
+
+	tab, err := browser.Context(ctx).Page(proto.TargetCreateTarget{URL: d.baseURL + "/ua/shutdowns"})
 	if err != nil {
 		return nil, fmt.Errorf("navigate: %w", err)
 	}
+	// Closed without ctx, which may be cancelled by now
+	defer func() {
+		if err := tab.Context(context.Background()).Close(); err != nil {
+			slog.Warn("[dtek] Failed to close page", "err", err)
+		}
+	}()
+	page := tab.Context(ctx)
 
-	// Wait for Imperva challenge
-	page.WaitLoad()
-	time.Sleep(5 * time.Second)
+	// Wait for Imperva challenge: the real page has the CSRF meta tag
+	if err := page.Timeout(dtekLoadTimeout).WaitLoad(); err != nil {
+		return nil, fmt.Errorf("wait for page load: %w", err)
+	}
+	if err := page.Timeout(dtekChallengeTimeout).WaitElementsMoreThan(`meta[name="csrf-token"]`, 0); err != nil {
+		return nil, fmt.Errorf("wait for csrf token: %w", err)
+	}
 
 	// Get cookies
-	cookies, err := page.Cookies([]string{"https://www.dtek-dnem.com.ua"})
+	cookies, err := page.Cookies([]string{d.baseURL})
 	if err != nil {
 		return nil, fmt.Errorf("get cookies: %w", err)
 	}
@@ -115,7 +359,7 @@ func (d *DtekClient) FetchShutdowns() (*DtekShutdown, error) {
 		return nil, fmt.Errorf("csrf attribute: %w", err)
 	}
 
-	log.Printf("[dtek] Got %d cookies, CSRF: %.20s", len(cookies), *csrfToken)
+	slog.Debug("[dtek] Got session", "cookies", len(cookies))
 
 	var cookieParts []string
 	for _, c := range cookies {
@@ -123,7 +367,17 @@ func (d *DtekClient) FetchShutdowns() (*DtekShutdown, error) {
 	}
 	cookieStr := strings.Join(cookieParts, "; ")
 
-	now := time.Now().Format("02.01.2006 15:04")
+	body, err := d.postHomeNum(ctx, *csrfToken, cookieStr)
+	if err != nil {
+		return nil, err
+	}
+	return parseShutdownsResponse(body, houses)
+}
+
+// postHomeNum makes the getHomeNum AJAX request for the configured street
+// with the session of the shutdowns page.
+func (d *DtekProvider) postHomeNum(ctx context.Context, csrfToken, cookies string) ([]byte, error) {
+	now := time.Now().In(kyivLocation).Format("02.01.2006 15:04")
 	formData := url.Values{
 		"method":         {"getHomeNum"},
 		"data[0][name]":  {"city"},
@@ -134,7 +388,7 @@ func (d *DtekClient) FetchShutdowns() (*DtekShutdown, error) {
 		"data[2][value]": {now},
 	}
 
-	req, err := http.NewRequest("POST", "https://www.dtek-dnem.com.ua/ua/ajax",
+	req, err := http.NewRequestWithContext(ctx, "POST", d.baseURL+"/ua/ajax",
 		strings.NewReader(formData.Encode()))
 	if err != nil {
 		return nil, err
@@ -142,13 +396,13 @@ func (d *DtekClient) FetchShutdowns() (*DtekShutdown, error) {
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
 	req.Header.Set("X-Requested-With", "XMLHttpRequest")
-	req.Header.Set("X-CSRF-Token", *csrfToken)
-	req.Header.Set("Referer", "https://www.dtek-dnem.com.ua/ua/shutdowns")
-	req.Header.Set("Origin", "https://www.dtek-dnem.com.ua")
-	req.Header.Set("Cookie", cookieStr)
+	req.Header.Set("X-CSRF-Token", csrfToken)
+	req.Header.Set("Referer", d.baseURL+"/ua/shutdowns")
+	req.Header.Set("Origin", d.baseURL)
+	req.Header.Set("Cookie", cookies)
 	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux aarch64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := d.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -159,8 +413,22 @@ func (d *DtekClient) FetchShutdowns() (*DtekShutdown, error) {
 		return nil, err
 	}
 
-	log.Printf("[dtek] Response status: %d, body: %.200s", resp.StatusCode, body)
+	slog.Debug("[dtek] <<<", "status", resp.StatusCode, "body", body)
+	return body, nil
+}
+
+// parseShutdownResponse extracts the house's outage from a getHomeNum AJAX
+// response. It returns nil if the house has no outage scheduled.
+func parseShutdownResponse(body []byte, house string) (*Shutdown, error) {
+	shutdowns, err := parseShutdownsResponse(body, []string{house})
+	if err != nil {
+		return nil, err
+	}
+	return shutdowns[house], nil
+}
 
+// parseShutdownsResponse is parseShutdownResponse for several houses.
+func parseShutdownsResponse(body []byte, houses []string) (map[string]*Shutdown, error) {
 	var dtekResp DtekResponse
 	if err := json.Unmarshal(body, &dtekResp); err != nil {
 		return nil, fmt.Errorf("parse response: %w, body: %s", err, body[:min(200, len(body))])
@@ -170,57 +438,155 @@ func (d *DtekClient) FetchShutdowns() (*DtekShutdown, error) {
 		return nil, fmt.Errorf("dtek returned result=false")
 	}
 
-	shutdown, ok := dtekResp.Data[d.house]
-	if !ok {
-		return nil, nil
+	shutdowns := make(map[string]*Shutdown, len(houses))
+	for _, house := range houses {
+		shutdowns[house] = nil
+		if shutdown, ok := dtekResp.Data[house]; ok {
+			shutdown.parseDates()
+			shutdowns[house] = &shutdown
+		}
 	}
-
-	return &shutdown, nil
+	return shutdowns, nil
 }
 
-const dtekCacheTTL = 10 * time.Minute
-
-func (d *DtekClient) ClearCache() {
+func (d *DtekProvider) ClearCache() {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.cacheHit = false
-	log.Printf("[dtek] Cache cleared")
+	slog.Debug("[dtek] Cache cleared")
 }
 
-func (d *DtekClient) GetShutdown() (*DtekShutdown, error) {
+func (d *DtekProvider) CachedAt() time.Time {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	return d.cachedAt
+}
 
-	if d.cacheHit && time.Since(d.cachedAt) < dtekCacheTTL {
-		return d.cachedValue, nil
+// GetShutdown returns the scheduled outage, or nil if there is none. A
+// result served from cache after a failed fetch has Stale set.
+func (d *DtekProvider) GetShutdown(ctx context.Context) (*Shutdown, error) {
+	shutdown, stale, err := d.getShutdown(ctx)
+	if shutdown != nil && stale {
+		cp := *shutdown
+		cp.Stale = true
+		shutdown = &cp
 	}
+	return shutdown, err
+}
 
-	shutdown, err := d.FetchShutdowns()
+// getShutdown returns the cached schedule or fetches a fresh one. If the
+// fetch fails but a previous successful value is younger than maxStale, that
+// value is returned with stale=true instead of the error. Only one fetch
+// runs at a time; while it does, callers get the cached value if there is
+// one and wait for the fetch otherwise.
+func (d *DtekProvider) getShutdown(ctx context.Context) (shutdown *Shutdown, stale bool, err error) {
+	d.mu.Lock()
+	if d.cacheHit && time.Since(d.cachedAt) < d.cacheTTL ||
+		d.fetching && !d.cachedAt.IsZero() {
+		shutdown = d.cachedValue
+		d.mu.Unlock()
+		return shutdown, false, nil
+	}
+	d.mu.Unlock()
+
+	v, err, _ := d.fetches.Do("shutdown", func() (any, error) {
+		return d.fetch(ctx)
+	})
+	if err == nil {
+		return v.(*Shutdown), false, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.cachedAt.IsZero() && time.Since(d.cachedAt) < d.maxStale {
+		slog.Warn("[dtek] Fetch failed, serving cached data", "from", d.cachedAt.Format("15:04"), "err", err)
+		return d.cachedValue, true, nil
+	}
+	return nil, false, err
+}
+
+// fetch scrapes the schedule unless the breaker is open, and caches it.
+// d.mu is released during the scrape.
+func (d *DtekProvider) fetch(ctx context.Context) (*Shutdown, error) {
+	d.mu.Lock()
+	now := time.Now()
+	if !d.breaker.allow(now) {
+		defer d.mu.Unlock()
+		return nil, fmt.Errorf("%w until %s after %d failed fetches", errCircuitOpen, d.breaker.openUntil.Format("15:04"), d.breaker.failures)
+	}
+	d.fetching = true
+	d.mu.Unlock()
+
+	shutdown, err := d.FetchShutdowns(ctx)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fetching = false
 	if err != nil {
+		if d.breaker.failure(now) {
+			slog.Warn("[dtek] Too many failed fetches, pausing", "failures", d.breaker.failures, "until", d.breaker.openUntil.Format("15:04"))
+		}
 		return nil, err
 	}
-
+	d.breaker.success()
 	d.cachedAt = time.Now()
 	d.cachedValue = shutdown
 	d.cacheHit = true
 	return shutdown, nil
 }
 
-func (d *DtekClient) ShutdownLine() string {
-	shutdown, err := d.GetShutdown()
+var errCircuitOpen = errors.New("fetching paused")
+
+// circuitBreaker stops calls to a failing dependency. After threshold
+// failures in a row it opens for cooldown; then one call is let through,
+// and its failure opens the breaker again while success closes it. Not safe
+// for concurrent use.
+type circuitBreaker struct {
+	threshold int // 0 disables the breaker
+	cooldown  time.Duration
+
+	failures  int
+	openUntil time.Time
+}
+
+// allow reports whether a call may be made at now.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	return b.threshold <= 0 || b.failures < b.threshold || !now.Before(b.openUntil)
+}
+
+func (b *circuitBreaker) success() {
+	b.failures = 0
+}
+
+// failure records a failed call and reports whether it opened the breaker.
+func (b *circuitBreaker) failure(now time.Time) bool {
+	b.failures++
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return false
+	}
+	b.openUntil = now.Add(b.cooldown)
+	return true
+}
+
+func (d *DtekProvider) ShutdownLine(ctx context.Context, l Lang) string {
+	shutdown, stale, err := d.getShutdown(ctx)
 	if err != nil {
-		log.Printf("[dtek] error: %v", err)
-		return "📋 ДТЕК: помилка отримання даних"
+		slog.Error("[dtek] Failed to get shutdown", "err", err)
+		return l.tr("dtek.line_error")
 	}
+
+	var line string
 	if shutdown == nil {
-		return "📋 ДТЕК: відключень немає"
+		line = l.tr("dtek.line_none")
+	} else {
+		start, end := shutdown.Period()
+		line = l.tr("dtek.line", start, end)
+		if c := shutdown.Countdown(l, time.Now()); c != "" {
+			line += ", " + c
+		}
 	}
-	return fmt.Sprintf("📋 ДТЕК: %s – %s", shutdown.StartDate, shutdown.EndDate)
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
+	if stale {
+		line += l.tr("dtek.line_stale")
 	}
-	return b
+	return line
 }
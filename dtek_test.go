@@ -1,13 +1,120 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 )
 
+// sampleDtekResponse is a trimmed getHomeNum response from dtek-dnem.com.ua.
+const sampleDtekResponse = `{
+	"result": true,
+	"data": {
+		"63": {
+			"sub_type": "Планові ремонтні роботи",
+			"start_date": "09:00 17.10.2026",
+			"end_date": "17:00 17.10.2026",
+			"type": "1",
+			"sub_type_reason": ["1100"]
+		},
+		"65": {
+			"sub_type": "Екстренні відключення",
+			"start_date": "17.10.2026 12:30",
+			"end_date": "17.10.2026 16:00",
+			"type": "2",
+			"sub_type_reason": []
+		}
+	},
+	"updateTimestamp": "10:42 17.10.2026"
+}`
+
+func TestParseShutdownResponse(t *testing.T) {
+	shutdown, err := parseShutdownResponse([]byte(sampleDtekResponse), "63")
+	if err != nil {
+		t.Fatalf("parseShutdownResponse: %v", err)
+	}
+	if shutdown == nil {
+		t.Fatal("shutdown = nil, want the outage for house 63")
+	}
+	if shutdown.Type != "1" || shutdown.SubType != "Планові ремонтні роботи" {
+		t.Errorf("Type, SubType = %q, %q", shutdown.Type, shutdown.SubType)
+	}
+	wantStart := time.Date(2026, 10, 17, 9, 0, 0, 0, kyivLocation)
+	wantEnd := time.Date(2026, 10, 17, 17, 0, 0, 0, kyivLocation)
+	if !shutdown.Start.Equal(wantStart) || !shutdown.End.Equal(wantEnd) {
+		t.Errorf("Start, End = %v, %v; want %v, %v", shutdown.Start, shutdown.End, wantStart, wantEnd)
+	}
+
+	// The other date layout DTEK uses
+	shutdown, err = parseShutdownResponse([]byte(sampleDtekResponse), "65")
+	if err != nil {
+		t.Fatalf("parseShutdownResponse: %v", err)
+	}
+	if want := time.Date(2026, 10, 17, 12, 30, 0, 0, kyivLocation); shutdown == nil || !shutdown.Start.Equal(want) {
+		t.Errorf("shutdown for house 65 = %+v, want start %v", shutdown, want)
+	}
+}
+
+func TestParseShutdownResponseHouseNotFound(t *testing.T) {
+	shutdown, err := parseShutdownResponse([]byte(sampleDtekResponse), "1")
+	if err != nil {
+		t.Fatalf("parseShutdownResponse: %v", err)
+	}
+	if shutdown != nil {
+		t.Errorf("shutdown = %+v, want nil", shutdown)
+	}
+}
+
+func TestParseShutdownsResponse(t *testing.T) {
+	shutdowns, err := parseShutdownsResponse([]byte(sampleDtekResponse), []string{"63", "65", "1"})
+	if err != nil {
+		t.Fatalf("parseShutdownsResponse: %v", err)
+	}
+	if len(shutdowns) != 3 {
+		t.Errorf("got %d houses, want 3", len(shutdowns))
+	}
+	if shutdowns["63"] == nil || shutdowns["65"] == nil {
+		t.Errorf("houses 63 and 65 have outages, got %+v", shutdowns)
+	}
+	if s, ok := shutdowns["1"]; !ok || s != nil {
+		t.Errorf("house 1 = %+v, %v; want a nil entry", s, ok)
+	}
+}
+
+func TestParseShutdownResponseErrors(t *testing.T) {
+	for name, body := range map[string]string{
+		"result false": `{"result": false, "data": {}}`,
+		"not json":     `<html>Request unsuccessful. Incapsula incident ID</html>`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseShutdownResponse([]byte(body), "63"); err == nil {
+				t.Error("want error")
+			}
+		})
+	}
+}
+
+// TestDtekFetch scrapes the live site with a real Chromium. It is skipped
+// unless DTEK_LIVE_TEST is set.
 func TestDtekFetch(t *testing.T) {
-	client := NewDtekClient("м. Підгороднє", "вул. Сагайдачного Петра", "1")
-	shutdown, err := client.FetchShutdowns()
+	if os.Getenv("DTEK_LIVE_TEST") == "" {
+		t.Skip("set DTEK_LIVE_TEST=1 to run against the live DTEK site")
+	}
+
+	client := NewDtekProvider(&Config{
+		DtekCity:          "м. Підгороднє",
+		DtekStreet:        "вул. Сагайдачного Петра",
+		DtekHouse:         "1",
+		DtekFetchAttempts: 1,
+	}, dtekSites["dtek-dnipro"])
+	defer client.Close()
+
+	shutdown, err := client.FetchShutdowns(t.Context())
 	if err != nil {
 		t.Fatalf("FetchShutdowns error: %v", err)
 	}
@@ -17,3 +124,62 @@ func TestDtekFetch(t *testing.T) {
 	}
 	fmt.Printf("Shutdown: %s → %s (%s)\n", shutdown.StartDate, shutdown.EndDate, shutdown.SubType)
 }
+
+func TestPostHomeNumCancelled(t *testing.T) {
+	// DTEK that doesn't answer until the test ends
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	d := NewDtekProvider(&Config{}, srv.URL)
+	ctx, cancel := context.WithCancel(t.Context())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := d.postHomeNum(ctx, "csrf", "")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("postHomeNum error = %v, want context.Canceled", err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("postHomeNum returned %v after cancel", took)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := circuitBreaker{threshold: 2, cooldown: time.Minute}
+	now := time.Now()
+
+	if !b.allow(now) || b.failure(now) {
+		t.Fatal("first failure must not open the breaker")
+	}
+	if !b.failure(now) {
+		t.Fatal("second failure must open the breaker")
+	}
+	if b.allow(now.Add(59 * time.Second)) {
+		t.Error("open breaker allowed a call during the cooldown")
+	}
+
+	// Half-open: one probe, whose failure reopens the breaker
+	probe := now.Add(time.Minute)
+	if !b.allow(probe) {
+		t.Fatal("breaker did not half-open after the cooldown")
+	}
+	if !b.failure(probe) || b.allow(probe.Add(time.Second)) {
+		t.Error("failed probe did not reopen the breaker")
+	}
+
+	b.success()
+	if !b.allow(probe.Add(time.Second)) {
+		t.Error("success did not close the breaker")
+	}
+
+	off := circuitBreaker{}
+	for range 10 {
+		if off.failure(now) || !off.allow(now) {
+			t.Fatal("disabled breaker opened")
+		}
+	}
+}
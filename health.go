@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health tracks poller liveness for the /healthz endpoint.
+type Health struct {
+	maxAge  time.Duration // a poll older than this makes the bot unhealthy
+	started time.Time
+
+	mu          sync.Mutex
+	polls       int
+	failures    int
+	lastSuccess time.Time
+	lastError   string
+	lastErrorAt time.Time
+	hasGrid     map[string]bool // station name → grid state
+}
+
+func NewHealth(maxAge time.Duration) *Health {
+	return &Health{
+		maxAge:  maxAge,
+		started: time.Now(),
+		hasGrid: make(map[string]bool),
+	}
+}
+
+// PollSucceeded records a successful poll of the station.
+func (h *Health) PollSucceeded(st Station, status *PowerStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.polls++
+	h.lastSuccess = time.Now()
+	h.hasGrid[st.name()] = status.HasGrid
+}
+
+// PollFailed records a failed poll.
+func (h *Health) PollFailed(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.polls++
+	h.failures++
+	h.lastError = err.Error()
+	h.lastErrorAt = time.Now()
+}
+
+// HealthStats is a snapshot of the poll counters for /uptime.
+type HealthStats struct {
+	Started     time.Time
+	Polls       int
+	Failures    int
+	LastError   string
+	LastErrorAt time.Time
+}
+
+// Stats returns the current poll counters.
+func (h *Health) Stats() HealthStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return HealthStats{
+		Started:     h.started,
+		Polls:       h.polls,
+		Failures:    h.failures,
+		LastError:   h.lastError,
+		LastErrorAt: h.lastErrorAt,
+	}
+}
+
+type healthResponse struct {
+	Healthy     bool            `json:"healthy"`
+	LastPoll    *time.Time      `json:"lastPoll"`
+	LastError   string          `json:"lastError,omitempty"`
+	LastErrorAt *time.Time      `json:"lastErrorAt,omitempty"`
+	HasGrid     map[string]bool `json:"hasGrid"`
+}
+
+// ServeHTTP answers 200 while polls succeed and 503 once the last
+// successful poll is older than maxAge.
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	resp := healthResponse{HasGrid: make(map[string]bool, len(h.hasGrid))}
+	for k, v := range h.hasGrid {
+		resp.HasGrid[k] = v
+	}
+	// Give the first poll the same grace period as later ones
+	since := h.started
+	if !h.lastSuccess.IsZero() {
+		t := h.lastSuccess
+		resp.LastPoll = &t
+		since = t
+	}
+	if h.lastError != "" {
+		t := h.lastErrorAt
+		resp.LastError = h.lastError
+		resp.LastErrorAt = &t
+	}
+	h.mu.Unlock()
+
+	resp.Healthy = time.Since(since) <= h.maxAge
+
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
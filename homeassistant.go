@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HAEntities are the Home Assistant entity IDs the inverter readings are
+// taken from. Generation and BatteryPower are optional.
+type HAEntities struct {
+	GridPower    string // W (or kW), import from the grid positive
+	BatterySOC   string // %
+	Consumption  string // W (or kW)
+	Generation   string // W (or kW)
+	BatteryPower string // W (or kW), discharge positive
+}
+
+// HomeAssistantSource reads inverter readings from entity states of a Home
+// Assistant instance that already polls the inverter, e.g. through the
+// Solarman integration. It serves a single station.
+type HomeAssistantSource struct {
+	baseURL    string
+	token      string
+	entities   HAEntities
+	th         GridThresholds
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	prevSOC *float64 // SOC of the previous reading
+}
+
+func NewHomeAssistantSource(cfg *Config) *HomeAssistantSource {
+	return &HomeAssistantSource{
+		baseURL:  strings.TrimSuffix(cfg.HAURL, "/"),
+		token:    cfg.HAToken,
+		entities: cfg.HAEntities,
+		th:       cfg.GridThresholds,
+		// Usually on the LAN, so PROXY_URL doesn't apply; NO_PROXY does
+		httpClient: newHTTPClient(10*time.Second, nil),
+	}
+}
+
+// haState is the part of GET /api/states/<entity_id> we use.
+type haState struct {
+	EntityID    string    `json:"entity_id"`
+	State       string    `json:"state"`
+	LastUpdated time.Time `json:"last_updated"`
+	// LastReported also moves when the value didn't change (HA 2024.3+)
+	LastReported time.Time `json:"last_reported"`
+	Attributes   struct {
+		Unit string `json:"unit_of_measurement"`
+	} `json:"attributes"`
+}
+
+// getState fetches one entity's state.
+func (h *HomeAssistantSource) getState(ctx context.Context, entityID string) (*haState, error) {
+	reqURL := h.baseURL + "/api/states/" + url.PathEscape(entityID)
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+h.token)
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request %s: %w", entityID, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", entityID, err)
+	}
+	slog.Debug("[ha] <<<", "entity", entityID, "status", resp.StatusCode, "body", string(body))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s: HTTP %d", entityID, resp.StatusCode)
+	}
+
+	var st haState
+	if err := json.Unmarshal(body, &st); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", entityID, err)
+	}
+	return &st, nil
+}
+
+// value converts a state to a number, scaling kW/kWh to W/Wh. ok is false
+// while the entity is unavailable or unknown.
+func (s *haState) value() (v float64, ok bool) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s.State), 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, false
+	}
+	if u := s.Attributes.Unit; strings.HasPrefix(u, "kW") {
+		v *= 1000
+	}
+	return v, true
+}
+
+func (h *HomeAssistantSource) GetPowerStatus(ctx context.Context, stationID int64, deviceSN string) (*PowerStatus, error) {
+	// Fill a station/latest response so grid detection works as with Deye
+	var station StationLatestResponse
+	var updated time.Time
+	available := 0
+	for _, e := range []struct {
+		id  string
+		dst **float64
+	}{
+		{h.entities.GridPower, &station.GridPower},
+		{h.entities.BatterySOC, &station.BatterySOC},
+		{h.entities.Consumption, &station.ConsumptionPower},
+		{h.entities.Generation, &station.GenerationPower},
+		{h.entities.BatteryPower, &station.BatteryPower},
+	} {
+		if e.id == "" {
+			continue
+		}
+		st, err := h.getState(ctx, e.id)
+		if err != nil {
+			return nil, err
+		}
+		v, ok := st.value()
+		if !ok {
+			slog.Warn("[ha] Entity has no value", "entity", e.id, "state", st.State)
+			continue
+		}
+		*e.dst = &v
+		available++
+		for _, t := range []time.Time{st.LastUpdated, st.LastReported} {
+			if t.After(updated) {
+				updated = t
+			}
+		}
+	}
+	if available == 0 {
+		return nil, fmt.Errorf("no Home Assistant entity has a value")
+	}
+	if p := station.BatteryPower; p != nil {
+		discharge, charge := max(*p, 0), max(-*p, 0)
+		station.DischargePower, station.ChargePower = &discharge, &charge
+	}
+	if !updated.IsZero() {
+		station.LastUpdateTime = float64(updated.Unix())
+	}
+
+	h.mu.Lock()
+	prevSOC := h.prevSOC
+	if soc := station.BatterySOC; soc != nil {
+		v := *soc
+		h.prevSOC = &v
+	}
+	h.mu.Unlock()
+
+	status, reason := buildPowerStatus(&station, &DeviceLatestResponse{}, prevSOC, h.th)
+	slog.Debug("[ha] Grid detection", "hasGrid", status.HasGrid, "reason", reason)
+	// Home Assistant has no device state; the inverter is online as long as
+	// it keeps reporting
+	status.DeviceOnline = true
+	status.DeviceState = deviceStateOnline
+	return status, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// newHTTPClient returns a client that goes through proxy, or through the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables when proxy is
+// nil.
+func newHTTPClient(timeout time.Duration, proxy *url.URL) *http.Client {
+	return newTLSHTTPClient(timeout, proxy, nil)
+}
+
+// newTLSHTTPClient is newHTTPClient with a custom TLS configuration; nil
+// keeps the defaults.
+func newTLSHTTPClient(timeout time.Duration, proxy *url.URL, tlsConfig *tls.Config) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+	if proxy != nil {
+		t.Proxy = http.ProxyURL(proxy)
+	}
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Timeout: timeout, Transport: t}
+}
+
+// pinnedTLSConfig trusts the CA certificates in caPEM instead of the system
+// pool (when not empty) and, if pins are given, requires one of them to
+// match a certificate of the verified chain. A pin is the base64 SHA-256 of
+// a certificate's SubjectPublicKeyInfo, optionally prefixed with "sha256/":
+//
+//	openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der |
+//	  openssl dgst -sha256 -binary | base64
+func pinnedTLSConfig(caPEM []byte, pins []string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(caPEM) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in PEM data")
+		}
+		cfg.RootCAs = pool
+	}
+
+	if len(pins) == 0 {
+		return cfg, nil
+	}
+	want := make([][]byte, 0, len(pins))
+	for _, p := range pins {
+		h, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(p), "sha256/"))
+		if err != nil || len(h) != sha256.Size {
+			return nil, fmt.Errorf("invalid pin %q: want a base64 SHA-256 hash", p)
+		}
+		want = append(want, h)
+	}
+	// VerifyConnection, unlike VerifyPeerCertificate, also runs on resumed
+	// sessions, so a pin mismatch always fails the handshake
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		for _, chain := range cs.VerifiedChains {
+			for _, cert := range chain {
+				h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if slices.ContainsFunc(want, func(w []byte) bool { return string(w) == string(h[:]) }) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("certificate of %s matches no pinned public key", cs.ServerName)
+	}
+	return cfg, nil
+}
+
+// browserProxy is the --proxy-server value for Chromium: PROXY_URL, else
+// HTTPS_PROXY from the environment. Chromium ignores credentials in it.
+func browserProxy(proxy *url.URL) string {
+	if proxy != nil {
+		return proxy.Scheme + "://" + proxy.Host
+	}
+	for _, key := range []string{"HTTPS_PROXY", "https_proxy"} {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// Lang selects the bundle user-facing messages are taken from.
+type Lang string
+
+const (
+	LangUK Lang = "uk"
+	LangEN Lang = "en"
+)
+
+// defaultLang is used when SVITLO_LANG is not set, and for keys missing
+// from another bundle.
+const defaultLang = LangUK
+
+// activeLang is SVITLO_LANG: the language of chats that haven't chosen
+// one in /settings and of the non-Telegram notifiers.
+var activeLang = defaultLang
+
+func parseLang(s string) (Lang, bool) {
+	l := Lang(strings.ToLower(strings.TrimSpace(s)))
+	_, ok := bundles[l]
+	return l, ok
+}
+
+func langNames() string {
+	names := make([]string, 0, len(bundles))
+	for l := range bundles {
+		names = append(names, string(l))
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// tr returns the message for key in l, formatted with args like
+// fmt.Sprintf when any are given.
+func (l Lang) tr(key string, args ...any) string {
+	msg, ok := bundles[l][key]
+	if !ok {
+		msg, ok = bundles[defaultLang][key]
+	}
+	if !ok {
+		slog.Error("[i18n] Missing message", "key", key, "lang", l)
+		return key
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// bundles holds every user-facing message by language and key. Message
+// templates (status, poweron, poweroff) live in templates.go.
+var bundles = map[Lang]map[string]string{
+	LangUK: {
+		// Commands and /help
+		"help.title":       "<b>Доступні команди</b>\n\n",
+		"help.status":      "стан електрики, батареї та графік ДТЕК",
+		"help.battery":     "заряд батареї та оцінка часу роботи",
+		"help.history":     "відключення за останні 24 години",
+		"help.stats":       "статистика відключень за тиждень і за годинами",
+		"help.chart":       "графік заряду та мережі, напр. /chart 24",
+		"help.export":      "завантажити журнал відключень у CSV, напр. /export 2024-01",
+		"help.next":        "коли чекати світло або наступне відключення",
+		"help.forecast":    "тип і причини відключення за графіком ДТЕК",
+		"help.raw":         "усі показники інвертора (для адміністраторів)",
+		"help.devices":     "пристрої в акаунті Deye (для адміністраторів)",
+		"help.broadcast":   "надіслати оголошення всім підписникам (для адміністраторів)",
+		"help.subscribe":   "увімкнути сповіщення або вибрати: all, poweron, poweroff",
+		"help.unsubscribe": "вимкнути сповіщення",
+		"help.mute":        "тимчасово вимкнути сповіщення, напр. /mute 2h",
+		"help.unmute":      "увімкнути сповіщення після /mute",
+		"help.settings":    "сповіщення, тихі години та мова",
+		"help.uptime":      "час роботи бота та стан з'єднань",
+		"help.help":        "список команд",
+		"help.start":       "привітання",
+		"start":            "Бот Світло активний. Використовуй /status щоб перевірити стан електрики, /help — список команд.",
+		"admin_only":       "Ця команда доступна лише адміністраторам.",
+		"broadcast.usage":  "Використання: /broadcast текст оголошення (можна з HTML-розміткою)",
+		"broadcast.title":  "<b>📢 Оголошення</b>\n\n",
+		"broadcast.sent":   "✅ Оголошення надіслано.",
+		"settings_failed":  "Не вдалося зберегти налаштування.",
+
+		"error.status":  "Помилка при отриманні статусу. Спробуйте пізніше.",
+		"error.history": "Помилка при отриманні історії.",
+		"error.device":  "Помилка при отриманні даних інвертора.",
+
+		"chart.usage":   "Використання: /chart [годин], наприклад /chart 24",
+		"chart.caption": "📈 Останні %d год",
+		"chart.title":   "%s — %d год",
+		"chart.no_data": "Недостатньо даних для графіка.",
+		"chart.grid":    "Мережа",
+		"chart.battery": "Батарея, %",
+
+		"raw.empty": "Інвертор не повернув даних.",
+		"raw.state": "Стан: %d, дані від %s",
+
+		"selftest.message": "✅ Самоперевірка Svitlo: повідомлення доходять.",
+
+		"error.devices":          "Не вдалося отримати список пристроїв.",
+		"devices.empty":          "В акаунті Deye немає пристроїв.",
+		"devices.title":          "<b>🔌 Пристрої Deye: %d</b>",
+		"devices.entry":          "<code>%s</code>\nСтанція: <code>%d</code> %s\nТип: %s, %s\nЗв'язок: %s",
+		"devices.status_online":  "🟢 онлайн",
+		"devices.status_offline": "⚪ офлайн",
+		"devices.status_alarm":   "🔴 тривога",
+		"devices.status_unknown": "невідомо (%d)",
+
+		// Subscriptions
+		"notify.all":          "усі сповіщення",
+		"notify.poweron":      "лише про появу світла",
+		"notify.poweroff":     "лише про зникнення світла",
+		"notify.none":         "сповіщення вимкнено",
+		"subscribe.usage":     "Використання: /subscribe all | poweron | poweroff | none",
+		"subscribe.done":      "✅ Тепер: %s.",
+		"unsubscribe.muted":   "🔕 Сповіщення вимкнено. /subscribe — увімкнути знову.",
+		"unsubscribe.left":    "👋 Ви відписалися. /subscribe — надіслати новий запит.",
+		"mute.usage":          "Використання: /mute 2h (до 168h); адміністратори: /mute 2h all — для всіх.",
+		"mute.done":           "🔕 Сповіщення вимкнено до %s. /unmute — увімкнути раніше.",
+		"mute.done_all":       "🔕 Сповіщення вимкнено для всіх до %s. /unmute all — увімкнути раніше.",
+		"mute.note":           "🔕 Сповіщення вимкнено до %s",
+		"unmute.usage":        "Використання: /unmute; адміністратори: /unmute all — для всіх.",
+		"unmute.done":         "🔔 Сповіщення знову увімкнено.",
+		"unmute.done_all":     "🔔 Сповіщення знову увімкнено для всіх.",
+		"onboarding.unknown":  "👋 Цей чат ще не підключено. Його ID: <code>%d</code>\n\nНадішліть /subscribe, щоб попросити доступ в адміністратора, або додайте цей ID до TELEGRAM_USER_IDS, якщо ви власник бота.",
+		"request.admin":       "🔔 Запит на підписку від %s",
+		"request.sent":        "Запит на підписку надіслано адміністратору. Я повідомлю, коли його розглянуть.",
+		"request.pending":     "Ваш запит на підписку ще чекає на розгляд адміністратора.",
+		"request.approve":     "✅ Схвалити",
+		"request.reject":      "❌ Відхилити",
+		"request.approved":    "✅ Схвалено",
+		"request.rejected":    "❌ Відхилено",
+		"request.welcome":     "✅ Запит схвалено! Тепер ви отримуватимете сповіщення про світло. /help — список команд.",
+		"request.declined":    "Запит на підписку відхилено.",
+		"callback.denied":     "Немає доступу",
+		"callback.admin_only": "Лише для адміністраторів",
+		"callback.not_found":  "Станцію не знайдено",
+		"callback.failed":     "Не вдалося оновити",
+		"callback.refreshed":  "Оновлено",
+		"callback.not_saved":  "Не вдалося зберегти",
+		"button.refresh":      "🔄 Оновити",
+
+		// /settings
+		"lang.name":                "🇺🇦 Українська",
+		"settings.title":           "<b>⚙️ Налаштування</b>\n\n",
+		"settings.notify":          "🔔 Сповіщення: %s\n",
+		"settings.quiet":           "🌙 Тихі години (%s): %s\n",
+		"settings.quiet_hold":      "сповіщення надходять після них",
+		"settings.quiet_ignore":    "сповіщення надходять одразу",
+		"settings.lang":            "🌐 Мова: %s\n",
+		"settings.saved":           "Збережено",
+		"settings.button_all":      "Усі",
+		"settings.button_poweron":  "Поява світла",
+		"settings.button_poweroff": "Зникнення світла",
+		"settings.button_none":     "Вимкнути",
+		"settings.button_hold":     "🌙 Тихі години: відкладати",
+		"settings.button_ignore":   "🔔 Тихі години: надсилати одразу",
+
+		// Status and alerts
+		"device.online":       "Онлайн",
+		"device.alarm":        "Тривога",
+		"device.offline":      "Офлайн",
+		"device.went_offline": "<b>📴 Інвертор офлайн</b>\n\nСтан мережі невідомий, доки він не повернеться.\n🕐 Останні дані: %s",
+		"device.raised_alarm": "<b>⚠️ Інвертор повідомляє про тривогу</b>\n\nПеревірте застосунок Deye.\n🕐 %s",
+		"device.back_online":  "<b>📶 Інвертор знову онлайн</b>\n🕐 %s",
+		"grid.quality":        "🔌 Мережа: %s",
+		"grid.out_of_range":   "⚠️ %s — поза нормою",
+		"quiet.summary":       "<b>🌙 Поки діяв тихий режим:</b>\n\n",
+		"quiet.power_on":      "%s ⚡ Світло з'явилось",
+		"quiet.power_off":     "%s ❌ Світло зникло",
+		"deye.restored":       "✅ Зв'язок з Deye Cloud відновлено",
+		"deye.lost":           "⚠️ Втрачено зв'язок з Deye Cloud. Сповіщення про світло можуть запізнюватися.",
+		"deye.stale":          "⚠️ Дані з інвертора застаріли: останнє оновлення %s. Сповіщення про світло призупинено, доки він не з'явиться на зв'язку.",
+		"deye.credentials":    "🔑 Deye Cloud відхиляє вхід. Перевірте DEYE_EMAIL, DEYE_PASSWORD, DEYE_APP_ID і DEYE_APP_SECRET та перезапустіть бота.",
+		"deye.rejected":       "⛔ Deye Cloud відхиляє запит: <code>%s</code>\nПеревірте DEYE_STATION_ID, DEYE_DEVICE_SN або DEYE_STATIONS та перезапустіть бота.",
+
+		"battery.title":       "<b>🔋 Батарея: %.0f%%</b>\n\n",
+		"battery.power":       "⚡ Потужність: %+.0fW\n",
+		"battery.charge":      "⬆️ Заряд: %.0fW\n",
+		"battery.discharge":   "⬇️ Розряд: %.0fW\n",
+		"battery.temperature": "🌡 Температура: %.0f°C\n",
+		"battery.until_full":  "⏳ До повного заряду: ~%s\n",
+		"battery.until_empty": "⏳ До розряду: ~%s\n",
+		"battery.low":         "<b>🪫 Батарея розряджається: %.0f%%</b>",
+		"runtime.unknown":     "невідомо",
+		"runtime.solar":       "сонце покриває споживання",
+		"battery.recovered":   "<b>🔋 Батарею заряджено: %.0f%%</b>",
+
+		"uptime.title":         "<b>⏱ Час роботи</b>\n\n",
+		"uptime.started":       "Запущено: %s (%s тому)\n",
+		"uptime.polls":         "Опитувань Deye: %d (успішних %d, невдалих %d)\n",
+		"uptime.last_error":    "Остання помилка (%s): <code>%s</code>\n",
+		"uptime.dtek_age":      "ДТЕК: дані отримано %s тому",
+		"uptime.dtek_none":     "ДТЕК: дані ще не отримано",
+		"uptime.dtek_disabled": "ДТЕК: вимкнено",
+
+		"export.usage":   "Вкажіть рік, місяць або день, напр. /export 2024-01, або нічого для всього журналу.",
+		"export.empty":   "За цей період змін стану мережі не записано.",
+		"export.caption": "📄 Змін стану мережі: %d",
+
+		"history.title":   "<b>📊 За останні 24 год</b>\n\n",
+		"history.none":    "⚡ Відключень не було",
+		"history.count":   "❌ Відключень: %d\n",
+		"history.total":   "⏱ Без світла загалом: %s\n",
+		"history.longest": "📏 Найдовше відключення: %s",
+
+		"stats.title":     "<b>📈 Статистика за %d днів</b>\n\n",
+		"stats.count":     "❌ Відключень: %d (≈%.1f на день)\n",
+		"stats.top_hours": "🕐 Найчастіше без світла: %s\n\n",
+
+		"summary.title":       "<b>📊 Підсумок за %s</b>\n\n",
+		"summary.generation":  "☀️ Генерація: %.1f кВт·год\n",
+		"summary.consumption": "🏠 Споживання: %.1f кВт·год\n",
+		"summary.purchase":    "🔌 З мережі: %.1f кВт·год\n",
+		"summary.cycles":      "🔋 Циклів батареї: %.2f\n",
+		"summary.no_energy":   "Дані про енергію недоступні.\n",
+		"summary.grid_up":     "⚡ Світло було: %s\n",
+		"summary.outages":     "❌ Відключень: %d (разом %s)\n",
+
+		"monthly.title":            "<b>📊 Підсумок за місяць %s</b>\n\n",
+		"monthly.generation":       "☀️ Генерація: %.1f кВт·год%s\n",
+		"monthly.consumption":      "🏠 Споживання: %.1f кВт·год%s\n",
+		"monthly.purchase":         "🔌 З мережі: %.1f кВт·год%s\n",
+		"monthly.change":           " (%+.0f%%)",
+		"monthly.self_consumption": "♻️ Використано власної генерації: %.0f%%\n",
+		"monthly.no_previous":      "Даних за попередній місяць для порівняння немає.\n",
+
+		"duration.minutes": "%d хв",
+		"duration.hours":   "%d год %d хв",
+
+		// DTEK
+		"dtek.disabled":      "Інтеграцію з ДТЕК вимкнено.",
+		"dtek.error":         "📋 ДТЕК: помилка отримання даних. Спробуйте пізніше.",
+		"dtek.line":          "📋 ДТЕК: %s – %s",
+		"dtek.line_none":     "📋 ДТЕК: відключень немає",
+		"dtek.line_error":    "📋 ДТЕК: помилка отримання даних",
+		"dtek.line_stale":    " (дані застарілі)",
+		"dtek.starts_in":     "почнеться через %s",
+		"dtek.ends_in":       "закінчиться через %s",
+		"dtek.type.1":        "планове",
+		"dtek.type.2":        "аварійне",
+		"dtek.type.3":        "стабілізаційне",
+		"dtek.subtype.GPV":   "згідно з графіком погодинних відключень",
+		"dtek.subtype.SGAV":  "згідно з графіком аварійних відключень",
+		"forecast.title":     "<b>📋 ДТЕК</b>\n\n",
+		"forecast.none":      "За вашою адресою відключень не заплановано.",
+		"forecast.kind":      "Відключення: <b>%s</b>\n",
+		"forecast.countdown": "⏳ Відключення %s\n",
+		"forecast.reasons":   "Причини:\n",
+		"forecast.stale":     "⚠️ Дані застарілі\n",
+		"next.off_until":     "❌ Світла немає\n⏳ За графіком ДТЕК повернеться о %s (через %s)",
+		"next.off_unknown":   "❌ Світла немає\nДТЕК не вказує, коли його повернуть.",
+		"next.on_until":      "⚡ Світло є\n⏳ Наступне відключення о %s (через %s)",
+		"next.outage_end":    " до %s",
+		"next.on_none":       "⚡ Світло є\nЗа графіком ДТЕК відключень не заплановано.",
+
+		// Deye hybrid inverter fault codes
+		"alarm.F13": "змінився режим роботи",
+		"alarm.F18": "апаратний надструм на стороні змінного струму",
+		"alarm.F20": "апаратний надструм на стороні постійного струму",
+		"alarm.F22": "аварійна зупинка",
+		"alarm.F23": "струм витоку змінного струму",
+		"alarm.F24": "низький опір ізоляції панелей",
+		"alarm.F26": "дисбаланс шини постійного струму",
+		"alarm.F29": "помилка зв'язку паралельної системи (CAN)",
+		"alarm.F35": "немає мережі змінного струму",
+		"alarm.F41": "паралельну систему зупинено",
+		"alarm.F42": "низька напруга мережі",
+		"alarm.F46": "несправність батареї",
+		"alarm.F47": "завелика частота мережі",
+		"alarm.F48": "замала частота мережі",
+		"alarm.F56": "низька напруга шини постійного струму",
+		"alarm.F58": "немає зв'язку з BMS батареї",
+		"alarm.F63": "дугове замикання (ARC)",
+		"alarm.F64": "перегрів радіатора",
+	},
+
+	LangEN: {
+		// Commands and /help
+		"help.title":       "<b>Available commands</b>\n\n",
+		"help.status":      "power, battery and the DTEK schedule",
+		"help.battery":     "battery charge and runtime estimate",
+		"help.history":     "outages in the last 24 hours",
+		"help.stats":       "outage statistics for the week, by hour of day",
+		"help.chart":       "charge and grid chart, e.g. /chart 24",
+		"help.export":      "download the outage log as CSV, e.g. /export 2024-01",
+		"help.next":        "when power returns or the next outage starts",
+		"help.forecast":    "type and reasons of the scheduled DTEK outage",
+		"help.raw":         "all inverter readings (admins only)",
+		"help.devices":     "devices on the Deye account (admins only)",
+		"help.broadcast":   "send an announcement to all subscribers (admins only)",
+		"help.subscribe":   "turn on alerts or pick: all, poweron, poweroff",
+		"help.unsubscribe": "turn off alerts",
+		"help.mute":        "silence alerts for a while, e.g. /mute 2h",
+		"help.unmute":      "turn alerts back on after /mute",
+		"help.settings":    "alerts, quiet hours and language",
+		"help.uptime":      "bot uptime and connection stats",
+		"help.help":        "list of commands",
+		"help.start":       "welcome",
+		"start":            "Svitlo bot is running. Use /status to check the power, /help for the list of commands.",
+		"admin_only":       "This command is for admins only.",
+		"broadcast.usage":  "Usage: /broadcast announcement text (HTML formatting allowed)",
+		"broadcast.title":  "<b>📢 Announcement</b>\n\n",
+		"broadcast.sent":   "✅ Announcement sent.",
+		"settings_failed":  "Failed to save the settings.",
+
+		"error.status":  "Failed to get the status. Please try again later.",
+		"error.history": "Failed to get the history.",
+		"error.device":  "Failed to get the inverter data.",
+
+		"chart.usage":   "Usage: /chart [hours], e.g. /chart 24",
+		"chart.caption": "📈 Last %d h",
+		"chart.title":   "%s — %d h",
+		"chart.no_data": "Not enough data for a chart.",
+		"chart.grid":    "Grid",
+		"chart.battery": "Battery, %",
+
+		"raw.empty": "The inverter returned no data.",
+		"raw.state": "State: %d, data from %s",
+
+		"selftest.message": "✅ Svitlo self-test: messages get through.",
+
+		"error.devices":          "Failed to get the device list.",
+		"devices.empty":          "There are no devices on the Deye account.",
+		"devices.title":          "<b>🔌 Deye devices: %d</b>",
+		"devices.entry":          "<code>%s</code>\nStation: <code>%d</code> %s\nType: %s, %s\nConnection: %s",
+		"devices.status_online":  "🟢 online",
+		"devices.status_offline": "⚪ offline",
+		"devices.status_alarm":   "🔴 alarm",
+		"devices.status_unknown": "unknown (%d)",
+
+		// Subscriptions
+		"notify.all":          "all alerts",
+		"notify.poweron":      "only when power comes back",
+		"notify.poweroff":     "only when power goes out",
+		"notify.none":         "alerts off",
+		"subscribe.usage":     "Usage: /subscribe all | poweron | poweroff | none",
+		"subscribe.done":      "✅ Now: %s.",
+		"unsubscribe.muted":   "🔕 Alerts are off. /subscribe to turn them back on.",
+		"unsubscribe.left":    "👋 You have unsubscribed. /subscribe to send a new request.",
+		"mute.usage":          "Usage: /mute 2h (up to 168h); admins: /mute 2h all for everyone.",
+		"mute.done":           "🔕 Alerts are muted until %s. /unmute to turn them on earlier.",
+		"mute.done_all":       "🔕 Alerts are muted for everyone until %s. /unmute all to turn them on earlier.",
+		"mute.note":           "🔕 Alerts are muted until %s",
+		"unmute.usage":        "Usage: /unmute; admins: /unmute all for everyone.",
+		"unmute.done":         "🔔 Alerts are back on.",
+		"unmute.done_all":     "🔔 Alerts are back on for everyone.",
+		"onboarding.unknown":  "👋 This chat isn't connected yet. Its ID: <code>%d</code>\n\nSend /subscribe to ask the admin for access, or add this ID to TELEGRAM_USER_IDS if you run the bot.",
+		"request.admin":       "🔔 Subscription request from %s",
+		"request.sent":        "Your subscription request was sent to the admin. I'll let you know once it's decided.",
+		"request.pending":     "Your subscription request is still waiting for the admin.",
+		"request.approve":     "✅ Approve",
+		"request.reject":      "❌ Reject",
+		"request.approved":    "✅ Approved",
+		"request.rejected":    "❌ Rejected",
+		"request.welcome":     "✅ Request approved! You'll now get power alerts. /help for the list of commands.",
+		"request.declined":    "Your subscription request was declined.",
+		"callback.denied":     "Access denied",
+		"callback.admin_only": "Admins only",
+		"callback.not_found":  "Station not found",
+		"callback.failed":     "Failed to refresh",
+		"callback.refreshed":  "Refreshed",
+		"callback.not_saved":  "Failed to save",
+		"button.refresh":      "🔄 Refresh",
+
+		// /settings
+		"lang.name":                "🇬🇧 English",
+		"settings.title":           "<b>⚙️ Settings</b>\n\n",
+		"settings.notify":          "🔔 Alerts: %s\n",
+		"settings.quiet":           "🌙 Quiet hours (%s): %s\n",
+		"settings.quiet_hold":      "alerts arrive afterwards",
+		"settings.quiet_ignore":    "alerts arrive right away",
+		"settings.lang":            "🌐 Language: %s\n",
+		"settings.saved":           "Saved",
+		"settings.button_all":      "All",
+		"settings.button_poweron":  "Power on",
+		"settings.button_poweroff": "Power off",
+		"settings.button_none":     "Turn off",
+		"settings.button_hold":     "🌙 Quiet hours: hold back",
+		"settings.button_ignore":   "🔔 Quiet hours: send right away",
+
+		// Status and alerts
+		"device.online":       "Online",
+		"device.alarm":        "Alarm",
+		"device.offline":      "Offline",
+		"device.went_offline": "<b>📴 Inverter offline</b>\n\nThe grid state is unknown until it comes back.\n🕐 Last data: %s",
+		"device.raised_alarm": "<b>⚠️ The inverter reports an alarm</b>\n\nCheck the Deye app.\n🕐 %s",
+		"device.back_online":  "<b>📶 Inverter back online</b>\n🕐 %s",
+		"grid.quality":        "🔌 Grid: %s",
+		"grid.out_of_range":   "⚠️ %s — out of range",
+		"quiet.summary":       "<b>🌙 During quiet hours:</b>\n\n",
+		"quiet.power_on":      "%s ⚡ Power came back",
+		"quiet.power_off":     "%s ❌ Power went out",
+		"deye.restored":       "✅ Connection to Deye Cloud restored",
+		"deye.lost":           "⚠️ Lost connection to Deye Cloud. Power alerts may be delayed.",
+		"deye.stale":          "⚠️ Inverter data is out of date: last update %s. Power alerts are paused until it reports again.",
+		"deye.credentials":    "🔑 Deye Cloud rejects the login. Check DEYE_EMAIL, DEYE_PASSWORD, DEYE_APP_ID and DEYE_APP_SECRET and restart the bot.",
+		"deye.rejected":       "⛔ Deye Cloud rejects the request: <code>%s</code>\nCheck DEYE_STATION_ID, DEYE_DEVICE_SN or DEYE_STATIONS and restart the bot.",
+
+		"battery.title":       "<b>🔋 Battery: %.0f%%</b>\n\n",
+		"battery.power":       "⚡ Power: %+.0fW\n",
+		"battery.charge":      "⬆️ Charging: %.0fW\n",
+		"battery.discharge":   "⬇️ Discharging: %.0fW\n",
+		"battery.temperature": "🌡 Temperature: %.0f°C\n",
+		"battery.until_full":  "⏳ Until full: ~%s\n",
+		"battery.until_empty": "⏳ Until empty: ~%s\n",
+		"battery.low":         "<b>🪫 Battery is running low: %.0f%%</b>",
+		"runtime.unknown":     "unknown",
+		"runtime.solar":       "solar covers the load",
+		"battery.recovered":   "<b>🔋 Battery charged again: %.0f%%</b>",
+
+		"uptime.title":         "<b>⏱ Uptime</b>\n\n",
+		"uptime.started":       "Started: %s (%s ago)\n",
+		"uptime.polls":         "Deye polls: %d (%d succeeded, %d failed)\n",
+		"uptime.last_error":    "Last error (%s): <code>%s</code>\n",
+		"uptime.dtek_age":      "DTEK: data fetched %s ago",
+		"uptime.dtek_none":     "DTEK: no data fetched yet",
+		"uptime.dtek_disabled": "DTEK: disabled",
+
+		"export.usage":   "Give a year, month or day, e.g. /export 2024-01, or nothing for the whole log.",
+		"export.empty":   "No grid changes were recorded in this period.",
+		"export.caption": "📄 Grid changes: %d",
+
+		"history.title":   "<b>📊 Last 24 hours</b>\n\n",
+		"history.none":    "⚡ No outages",
+		"history.count":   "❌ Outages: %d\n",
+		"history.total":   "⏱ Without power in total: %s\n",
+		"history.longest": "📏 Longest outage: %s",
+
+		"stats.title":     "<b>📈 Statistics for %d days</b>\n\n",
+		"stats.count":     "❌ Outages: %d (≈%.1f per day)\n",
+		"stats.top_hours": "🕐 Most often without power: %s\n\n",
+
+		"summary.title":       "<b>📊 Summary for %s</b>\n\n",
+		"summary.generation":  "☀️ Generation: %.1f kWh\n",
+		"summary.consumption": "🏠 Consumption: %.1f kWh\n",
+		"summary.purchase":    "🔌 From grid: %.1f kWh\n",
+		"summary.cycles":      "🔋 Battery cycles: %.2f\n",
+		"summary.no_energy":   "Energy data is unavailable.\n",
+		"summary.grid_up":     "⚡ Power was on: %s\n",
+		"summary.outages":     "❌ Outages: %d (%s in total)\n",
+
+		"monthly.title":            "<b>📊 Summary for %s</b>\n\n",
+		"monthly.generation":       "☀️ Generation: %.1f kWh%s\n",
+		"monthly.consumption":      "🏠 Consumption: %.1f kWh%s\n",
+		"monthly.purchase":         "🔌 From grid: %.1f kWh%s\n",
+		"monthly.change":           " (%+.0f%%)",
+		"monthly.self_consumption": "♻️ Self-consumed generation: %.0f%%\n",
+		"monthly.no_previous":      "No data for the previous month to compare with.\n",
+
+		"duration.minutes": "%d min",
+		"duration.hours":   "%d h %d min",
+
+		// DTEK
+		"dtek.disabled":      "The DTEK integration is turned off.",
+		"dtek.error":         "📋 DTEK: failed to get data. Please try again later.",
+		"dtek.line":          "📋 DTEK: %s – %s",
+		"dtek.line_none":     "📋 DTEK: no outages",
+		"dtek.line_error":    "📋 DTEK: failed to get data",
+		"dtek.line_stale":    " (out of date)",
+		"dtek.starts_in":     "starts in %s",
+		"dtek.ends_in":       "ends in %s",
+		"dtek.type.1":        "planned",
+		"dtek.type.2":        "emergency",
+		"dtek.type.3":        "stabilization",
+		"dtek.subtype.GPV":   "per the hourly outage schedule",
+		"dtek.subtype.SGAV":  "per the emergency outage schedule",
+		"forecast.title":     "<b>📋 DTEK</b>\n\n",
+		"forecast.none":      "No outages are scheduled for your address.",
+		"forecast.kind":      "Outage: <b>%s</b>\n",
+		"forecast.countdown": "⏳ The outage %s\n",
+		"forecast.reasons":   "Reasons:\n",
+		"forecast.stale":     "⚠️ The data is out of date\n",
+		"next.off_until":     "❌ No power\n⏳ DTEK schedules it back at %s (in %s)",
+		"next.off_unknown":   "❌ No power\nDTEK doesn't say when it comes back.",
+		"next.on_until":      "⚡ Power is on\n⏳ Next outage at %s (in %s)",
+		"next.outage_end":    " until %s",
+		"next.on_none":       "⚡ Power is on\nNo outages on the DTEK schedule.",
+
+		// Deye hybrid inverter fault codes
+		"alarm.F13": "working mode changed",
+		"alarm.F18": "AC overcurrent (hardware)",
+		"alarm.F20": "DC overcurrent (hardware)",
+		"alarm.F22": "emergency stop",
+		"alarm.F23": "AC leakage current",
+		"alarm.F24": "low PV insulation resistance",
+		"alarm.F26": "DC busbar imbalance",
+		"alarm.F29": "parallel system communication (CAN) fault",
+		"alarm.F35": "no AC grid",
+		"alarm.F41": "parallel system stopped",
+		"alarm.F42": "grid voltage too low",
+		"alarm.F46": "battery fault",
+		"alarm.F47": "grid frequency too high",
+		"alarm.F48": "grid frequency too low",
+		"alarm.F56": "DC busbar voltage too low",
+		"alarm.F58": "no communication with the battery BMS",
+		"alarm.F63": "arc fault (ARC)",
+		"alarm.F64": "heat sink overheating",
+	},
+}
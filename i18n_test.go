@@ -0,0 +1,35 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+)
+
+var formatVerbRe = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+
+// TestBundlesComplete checks that every language has the same keys as the
+// default one and uses the same format verbs in each message.
+func TestBundlesComplete(t *testing.T) {
+	base := bundles[defaultLang]
+	for lang, bundle := range bundles {
+		for key, msg := range base {
+			other, ok := bundle[key]
+			if !ok {
+				t.Errorf("%s: missing %q", lang, key)
+				continue
+			}
+			if want, got := formatVerbRe.FindAllString(msg, -1), formatVerbRe.FindAllString(other, -1); !slices.Equal(want, got) {
+				t.Errorf("%s: %q has verbs %v, want %v", lang, key, got, want)
+			}
+		}
+		for key := range bundle {
+			if _, ok := base[key]; !ok {
+				t.Errorf("%s: unknown key %q", lang, key)
+			}
+		}
+		if _, ok := defaultTemplates[lang]; !ok {
+			t.Errorf("%s: no default templates", lang)
+		}
+	}
+}
@@ -1,148 +1,651 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"crypto/rand"
+	"flag"
 	"fmt"
+	"html"
 	"log"
+	"log/slog"
+	"math"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 )
 
 func main() {
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	configPath := flag.String("config", os.Getenv("SVITLO_CONFIG"), "path to a YAML config file (env vars override it)")
+	selfTest := flag.Bool("selftest", false, "check every integration once, print the results and exit (non-zero on failure)")
+	flag.Parse()
 
-	cfg, err := LoadConfig()
+	cfg, err := LoadConfig(*configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
 
-	deye := NewDeyeClient(cfg)
-	bot := NewTelegramBot(cfg.TelegramBotToken, cfg.TelegramUserIDs)
-	dtek := NewDtekClient("м. Підгороднє", "вул. Сагайдачного Петра", "63")
+	activeLang = cfg.Lang
+	// Every timestamp and schedule follows TIMEZONE rather than the host's zone
+	time.Local = cfg.Location
+	if cfg.TemplatesDir != "" {
+		if err := LoadTemplates(cfg.TemplatesDir); err != nil {
+			fatal("Failed to load message templates", "err", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if *selfTest {
+		if !runSelfTest(ctx, cfg, os.Stdout) {
+			cancel()
+			os.Exit(1)
+		}
+		return
+	}
 
-	log.Println("Authenticating with Deye Cloud...")
-	if err := deye.Authenticate(); err != nil {
-		log.Fatalf("Deye authentication failed: %v", err)
+	deye := NewDeyeClient(cfg)
+	settings, err := LoadSettings(cfg.SettingsPath)
+	if err != nil {
+		fatal("Failed to load settings", "err", err)
+	}
+	bot := NewTelegramBot(cfg, settings)
+	if me, err := bot.GetMe(); err != nil {
+		slog.Warn("[telegram] Failed to get bot info, accepting commands for any bot in groups", "err", err)
+	} else {
+		slog.Info("[telegram] Running as bot", "id", me.ID, "username", me.Username, "name", me.FirstName,
+			"privacyMode", !me.CanReadAllGroupMessages)
+	}
+	var dtek ShutdownProvider
+	if cfg.DtekEnabled {
+		if dtek, err = NewShutdownProvider(cfg); err != nil {
+			fatal("Failed to create shutdown provider", "err", err)
+		}
 	}
-	log.Println("Deye authentication successful")
 
-	// Auto-discover station ID and device SN if not set
-	if cfg.DeyeStationID == 0 || cfg.DeyeDeviceSN == "" {
-		log.Println("DEYE_STATION_ID or DEYE_DEVICE_SN not set, discovering devices...")
-		devices, err := deye.GetDeviceList()
+	var power PowerSource = deye
+	if cfg.DeyeReplayFile != "" {
+		replay, err := NewReplaySource(cfg.DeyeReplayFile, cfg.GridThresholds)
 		if err != nil {
-			log.Fatalf("Failed to get device list: %v", err)
-		}
-		if len(devices.Devices) == 0 {
-			log.Fatal("No devices found on your Deye account")
+			fatal("Failed to load replay file", "err", err)
 		}
-		log.Printf("Found %d device(s):", len(devices.Devices))
-		for i, d := range devices.Devices {
-			log.Printf("  [%d] SN: %s | StationID: %d | Type: %s | Name: %s | Station: %s | Status: %d",
-				i, d.DeviceSn, d.StationID, d.DeviceType, d.ProductName, d.StationName, d.ConnectStatus)
+		slog.Warn("Replaying recorded Deye responses instead of polling Deye Cloud", "file", cfg.DeyeReplayFile)
+		power = replay
+		// Recorded timestamps are old by nature
+		cfg.DeyeStaleAfter = 0
+		if len(cfg.Stations) == 0 {
+			cfg.Stations = []Station{{ID: cfg.DeyeStationID, DeviceSN: cfg.DeyeDeviceSN}}
 		}
-		// Use first device
-		first := devices.Devices[0]
-		if cfg.DeyeStationID == 0 {
-			cfg.DeyeStationID = first.StationID
-			log.Printf("Using StationID: %d (set DEYE_STATION_ID=%d in .env to skip discovery)", first.StationID, first.StationID)
+	} else if cfg.HAURL != "" {
+		slog.Info("Reading inverter status from Home Assistant", "url", cfg.HAURL)
+		power = NewHomeAssistantSource(cfg)
+		// One set of entities describes one inverter
+		cfg.Stations = []Station{{ID: cfg.DeyeStationID, DeviceSN: cfg.DeyeDeviceSN}}
+	} else if deye.HasValidToken() {
+		slog.Info("Using cached Deye token, skipping authentication")
+	} else {
+		slog.Info("Authenticating with Deye Cloud")
+		if err := deye.Authenticate(ctx); err != nil {
+			fatal("Deye authentication failed", "err", err)
 		}
-		if cfg.DeyeDeviceSN == "" {
-			cfg.DeyeDeviceSN = first.DeviceSn
-			log.Printf("Using DeviceSN: %s (set DEYE_DEVICE_SN=%s in .env to skip discovery)", first.DeviceSn, first.DeviceSn)
+		slog.Info("Deye authentication successful")
+	}
+
+	// Single-station mode (DEYE_STATIONS not set)
+	if len(cfg.Stations) == 0 {
+		// Auto-discover station ID and device SN if not set
+		if cfg.DeyeStationID == 0 || cfg.DeyeDeviceSN == "" {
+			slog.Info("DEYE_STATION_ID or DEYE_DEVICE_SN not set, discovering devices")
+			devices, err := deye.GetDeviceList(ctx)
+			if err != nil {
+				fatal("Failed to get device list", "err", err)
+			}
+			if len(devices.Devices) == 0 {
+				fatal("No devices found on your Deye account")
+			}
+			slog.Info("Found devices", "count", len(devices.Devices))
+			for i, d := range devices.Devices {
+				slog.Info("Device", "index", i, "sn", d.DeviceSn, "stationId", d.StationID, "type", d.DeviceType,
+					"name", d.ProductName, "station", d.StationName, "status", d.ConnectStatus)
+			}
+			// Use first device
+			first := devices.Devices[0]
+			if cfg.DeyeStationID == 0 {
+				cfg.DeyeStationID = first.StationID
+				slog.Info("Using first station; set DEYE_STATION_ID in .env to skip discovery", "stationId", first.StationID)
+			}
+			if cfg.DeyeDeviceSN == "" {
+				cfg.DeyeDeviceSN = first.DeviceSn
+				slog.Info("Using first device; set DEYE_DEVICE_SN in .env to skip discovery", "sn", first.DeviceSn)
+			}
 		}
+
+		cfg.Stations = []Station{{ID: cfg.DeyeStationID, DeviceSN: cfg.DeyeDeviceSN}}
+	}
+	for _, st := range cfg.Stations {
+		slog.Info("Monitoring station", "station", st.name(), "stationId", st.ID, "sn", st.DeviceSN)
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	store, err := NewStorage(cfg.DBPath)
+	if err != nil {
+		fatal("Failed to open database", "err", err)
+	}
+	defer store.Close()
 
 	var wg sync.WaitGroup
 
+	metrics := NewMetrics()
+	health := NewHealth(2 * cfg.MaxPollInterval())
+
+	// Metrics and health may share a listen address
+	muxes := make(map[string]*http.ServeMux)
+	handle := func(addr, pattern string, handler http.Handler) {
+		if addr == "" {
+			return
+		}
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		muxes[addr].Handle(pattern, handler)
+	}
+	handle(cfg.MetricsAddr, "/metrics", metrics)
+	handle(cfg.HealthAddr, "/healthz", health)
+
+	// Webhook mode: the server queues updates until the handlers below read them
+	var webhookUpdates chan Update
+	var webhookSecret string
+	if cfg.WebhookURL != "" {
+		webhookUpdates = make(chan Update, 100)
+		webhookSecret = rand.Text()
+		u, _ := url.Parse(cfg.WebhookURL) // validated in LoadConfig
+		path := u.Path
+		if path == "" {
+			path = "/"
+		}
+		handle(cfg.WebhookListenAddr, "POST "+path, webhookHandler(webhookSecret, webhookUpdates))
+	}
+	for addr, mux := range muxes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runHTTPServer(ctx, addr, mux)
+		}()
+	}
+
+	notifiers := Notifiers{bot}
+	if cfg.DryRun {
+		// The bot logs what it would send; the other sinks stay silent
+		slog.Warn("Dry run: messages are logged, not sent")
+	} else {
+		if cfg.DiscordWebhookURL != "" {
+			notifiers = append(notifiers, globallyMuted{NewDiscordNotifier(cfg.DiscordWebhookURL, cfg.ProxyURL), settings})
+		}
+		if cfg.NtfyURL != "" {
+			notifiers = append(notifiers, globallyMuted{NewNtfyNotifier(cfg.NtfyURL, cfg.NtfyTopic, cfg.NtfyToken, cfg.ProxyURL), settings})
+		}
+	}
+
+	var notifier Notifier = notifiers
+	if cfg.BroadcastDedupeSec > 0 {
+		notifier = newDedupedNotifier(notifiers, time.Duration(cfg.BroadcastDedupeSec)*time.Second)
+	}
+
+	app := &App{
+		cfg:       cfg,
+		deye:      deye,
+		power:     power,
+		bot:       bot,
+		notifiers: notifier,
+		dtek:      dtek,
+		store:     store,
+		settings:  settings,
+		metrics:   metrics,
+		health:    health,
+
+		onboarding:     newReplyLimiter(onboardingInterval),
+		subscribeLimit: newReplyLimiter(subscribeInterval),
+		requests:       newSubscriptionRequests(),
+	}
+
 	// Deye polling goroutine
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		runDeyePoller(ctx, deye, bot, cfg, dtek)
+		runPowerPoller(ctx, app)
 	}()
 
 	// Telegram updates goroutine
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		runTelegramPoller(ctx, deye, bot, cfg, dtek)
+		if cfg.WebhookURL != "" {
+			runTelegramWebhook(ctx, app, webhookSecret, webhookUpdates)
+		} else {
+			runTelegramPoller(ctx, app)
+		}
 	}()
 
+	if cfg.DailySummaryAt != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runDailySummary(ctx, app)
+		}()
+	}
+
+	if cfg.MonthlyReportAt != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runMonthlyReport(ctx, app)
+		}()
+	}
+
 	// Wait for shutdown signal
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	sig := <-sigCh
-	log.Printf("Received signal %v, shutting down...", sig)
+	slog.Info("Shutting down", "signal", sig)
 	cancel()
 	wg.Wait()
-	log.Println("Shutdown complete")
+	if dtek != nil {
+		dtek.Close()
+	}
+	slog.Info("Shutdown complete")
 }
 
-func runDeyePoller(ctx context.Context, deye *DeyeClient, bot *TelegramBot, cfg *Config, dtek *DtekClient) {
-	ticker := time.NewTicker(time.Duration(cfg.PollIntervalSec) * time.Second)
-	defer ticker.Stop()
+// fatal logs an error and exits; the slog counterpart of log.Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
 
-	var lastHasGrid *bool
+// App bundles the clients and settings shared by the pollers and command handlers.
+type App struct {
+	cfg  *Config
+	deye *DeyeClient
+	// power supplies readings to the poller and status commands: deye, a
+	// ReplaySource with DEYE_REPLAY_FILE or a HomeAssistantSource with HA_URL
+	power PowerSource
+	bot   *TelegramBot
+	// notifiers receive automatic alerts; bot is always one of them
+	notifiers Notifier
+	dtek      ShutdownProvider // nil when DTEK is disabled
+	store     *Storage
+	settings  *SettingsStore
+	metrics   *Metrics
+	health    *Health
+	// onboarding and subscribeLimit limit the replies to chats not allowed
+	// to use the bot
+	onboarding     *replyLimiter
+	subscribeLimit *replyLimiter
+	// requests holds the /subscribe requests awaiting an admin
+	requests *subscriptionRequests
+}
+
+// runPowerPoller polls app.power for every station and sends alerts on
+// changes until ctx is cancelled.
+func runPowerPoller(ctx context.Context, app *App) {
+	cfg, power, bot, notifiers, dtek, store, metrics, health := app.cfg, app.power, app.bot, app.notifiers, app.dtek, app.store, app.metrics, app.health
+
+	interval := cfg.PollInterval(time.Now())
+
+	states := make(map[string]*stationState) // keyed by Station.key()
+	// staleAlerted holds the stations whose stale data alert was sent for
+	// the current snapshot, keyed by Station.key()
+	staleAlerted := make(map[string]bool)
+	debounce := time.Duration(cfg.GridDebounceSec) * time.Second
+	var suppressed []Localized // alerts held back during quiet hours
+	// rejected is a Deye error this cycle that retrying won't fix, such as
+	// a rejected login or an unknown station
+	var rejected *DeyeError
+	rejectedAlerted := false
 
-	checkAndNotify := func() {
-		status, err := deye.GetPowerStatus(cfg.DeyeStationID, cfg.DeyeDeviceSN)
+	// checkAndNotify polls one station and reports whether the poll succeeded.
+	checkAndNotify := func(st Station) bool {
+		pollCtx, cancel := context.WithTimeout(ctx, interval)
+		status, err := power.GetPowerStatus(pollCtx, st.ID, st.DeviceSN)
+		cancel()
 		if err != nil {
-			log.Printf("[deye] Failed to get power status: %v", err)
-			return
+			slog.Error("[deye] Failed to get power status", "station", st.name(), "err", err)
+			if de := fatalDeyeError(err); de != nil {
+				rejected = de
+			}
+			metrics.PollError(st)
+			health.PollFailed(err)
+			return false
+		}
+		metrics.Observe(st, status)
+		health.PollSucceeded(st, status)
+		if err := store.RecordSample(st, time.Now(), status); err != nil {
+			slog.Error("[db] Failed to record sample", "station", st.name(), "err", err)
 		}
 
-		log.Printf("[deye] Grid: %.0fW | Purchase: %.0fW | Gen: %.0fW | Cons: %.0fW | SOC: %.0f%% | Online: %v",
-			status.GridPower, status.PurchasePower,
-			status.GenerationPower, status.ConsumptionPower,
-			status.BatterySOC, status.DeviceOnline)
+		slog.Debug("[deye] Poll",
+			"station", st.name(),
+			"grid", status.GridPower, "purchase", status.PurchasePower,
+			"generation", status.GenerationPower, "consumption", status.ConsumptionPower,
+			"soc", status.BatterySOC, "online", status.DeviceOnline)
 
 		currentHasGrid := status.HasGrid
 
-		if lastHasGrid == nil {
-			// First check — save state, send current status
-			lastHasGrid = &currentHasGrid
-			msg := formatStatusMessage(status, dtek.ShutdownLine())
-			bot.Broadcast(msg)
-			log.Printf("[deye] Initial state: hasGrid=%v", currentHasGrid)
-			return
+		// A device that stopped reporting leaves Deye serving its last
+		// snapshot; alerts based on it would be bogus, and so would an
+		// initial state taken from it
+		if updated := time.Unix(int64(status.LastUpdateTime), 0); cfg.DeyeStaleAfter > 0 && status.LastUpdateTime > 0 &&
+			time.Since(updated) > cfg.DeyeStaleAfter {
+			slog.Warn("[deye] Inverter data is stale, skipping alerts", "station", st.name(), "updated", updated.Format("2006-01-02 15:04"))
+			if !staleAlerted[st.key()] {
+				staleAlerted[st.key()] = true
+				notifiers.Broadcast(AlertInfo, withStationLabels(st, localize("deye.stale", formatTime(status.LastUpdateTime))))
+			}
+			return true
+		}
+		delete(staleAlerted, st.key())
+
+		state, seen := states[st.key()]
+		if !seen {
+			last, err := store.LastGridEvent(st)
+			if err != nil {
+				slog.Error("[db] Failed to get last grid event", "station", st.name(), "err", err)
+			}
+			if last != nil {
+				// Carry on from the state recorded before the restart, so
+				// only a change that happened meanwhile is announced
+				state = &stationState{hasGrid: last.HasGrid, deviceState: status.DeviceState}
+				states[st.key()] = state
+				slog.Info("[deye] Restored state", "station", st.name(), "hasGrid", last.HasGrid,
+					"since", last.Time.Format("2006-01-02 15:04"))
+			}
+		}
+		if state == nil {
+			// First check ever — save state, send current status
+			states[st.key()] = &stationState{hasGrid: currentHasGrid, deviceState: status.DeviceState}
+			recordGridEvent(store, st, status)
+			bot.BroadcastStatus(withStationLabels(st, func(l Lang) string {
+				return formatStatusMessage(l, status, shutdownLine(ctx, l, dtek))
+			}), func(l Lang) *InlineKeyboardMarkup {
+				return refreshKeyboard(l, []Station{st})
+			})
+			slog.Info("[deye] Initial state", "station", st.name(), "hasGrid", currentHasGrid)
+			return true
+		}
+
+		if status.DeviceState != 0 && status.DeviceState != state.deviceState {
+			slog.Info("[deye] Device state changed", "station", st.name(), "from", state.deviceState, "to", status.DeviceState)
+			if key := deviceStateChangeKey(state.deviceState, status.DeviceState); key != "" {
+				alarms := status.Alarms
+				notifiers.Broadcast(AlertInfo, withStationLabels(st, func(l Lang) string {
+					msg := l.tr(key, formatTime(status.LastUpdateTime))
+					if len(alarms) > 0 {
+						msg += "\n\n" + formatAlarms(l, alarms)
+					}
+					return msg
+				}))
+			}
+			state.deviceState = status.DeviceState
+		}
+
+		if cfg.BatteryLowSOC > 0 && state.updateBatteryLow(currentHasGrid, status.BatterySOC, cfg.BatteryLowSOC) {
+			key := "battery.recovered"
+			if state.batteryLow {
+				key = "battery.low"
+			}
+			slog.Info("[deye] Battery level alert", "station", st.name(), "soc", status.BatterySOC, "low", state.batteryLow)
+			notifiers.Broadcast(AlertInfo, withStationLabels(st, localize(key, status.BatterySOC)))
+		}
+
+		if !state.confirmGrid(currentHasGrid, time.Now(), debounce) {
+			if state.pending {
+				slog.Info("[deye] Pending state change",
+					"station", st.name(), "hasGrid", currentHasGrid, "since", state.pendingSince.Format("15:04:05"))
+			}
+			return true
 		}
 
-		if currentHasGrid != *lastHasGrid {
-			// State changed! Clear DTEK cache so fresh data is fetched.
+		// State changed! Clear DTEK cache so fresh data is fetched.
+		if dtek != nil {
 			dtek.ClearCache()
-			*lastHasGrid = currentHasGrid
-			var msg string
+		}
+		var outage time.Duration
+		if currentHasGrid {
+			outage = outageSince(store, st, time.Now())
+		}
+		recordGridEvent(store, st, status)
+		kind := AlertPowerOff
+		if currentHasGrid {
+			kind = AlertPowerOn
+		}
+		msg := withStationLabels(st, func(l Lang) string {
 			if currentHasGrid {
-				msg = formatPowerOnMessage(status, dtek.ShutdownLine())
-			} else {
-				msg = formatPowerOffMessage(status, dtek.ShutdownLine())
+				return formatPowerOnMessage(l, status, shutdownLine(ctx, l, dtek), outage)
 			}
-			bot.Broadcast(msg)
-			log.Printf("[deye] State changed: hasGrid=%v", currentHasGrid)
+			return formatPowerOffMessage(l, status, shutdownLine(ctx, l, dtek), cfg.DeyeBatteryCapacityWh)
+		})
+		slog.Info("[deye] State changed", "station", st.name(), "hasGrid", currentHasGrid)
+
+		if now := time.Now(); cfg.QuietHours != nil && cfg.QuietHours.Contains(now) {
+			slog.Info("[deye] Quiet hours, alert queued", "station", st.name(), "quietHours", cfg.QuietHours.String())
+			suppressed = append(suppressed, withStationLabels(st, func(l Lang) string {
+				return formatQuietSummaryLine(l, now, currentHasGrid)
+			}))
+			bot.BroadcastDuringQuietHours(kind, msg)
+			return true
 		}
+		notifiers.Broadcast(kind, msg)
+		return true
 	}
 
-	// First check immediately
-	checkAndNotify()
+	// checkAll polls every station and reports whether any poll succeeded.
+	checkAll := func() bool {
+		ok := false
+		for _, st := range cfg.Stations {
+			if checkAndNotify(st) {
+				ok = true
+			}
+		}
 
+		// Quiet hours are over — deliver what happened meanwhile
+		if len(suppressed) > 0 && !cfg.QuietHours.Contains(time.Now()) {
+			lines := suppressed
+			notifiers.Broadcast(AlertQuietSummary, func(l Lang) string {
+				var b strings.Builder
+				b.WriteString(l.tr("quiet.summary"))
+				for i, line := range lines {
+					if i > 0 {
+						b.WriteByte('\n')
+					}
+					b.WriteString(line(l))
+				}
+				return b.String()
+			})
+			suppressed = nil
+		}
+		return ok
+	}
+
+	failures := 0 // consecutive cycles in which every poll failed
 	for {
+		rejected = nil
+		if next := cfg.PollInterval(time.Now()); next != interval {
+			slog.Info("[deye] Poll interval changed", "from", interval, "to", next)
+			interval = next
+		}
+		if checkAll() {
+			if failures >= deyeOutageAlertAfter {
+				notifiers.Broadcast(AlertInfo, localize("deye.restored"))
+			}
+			failures = 0
+		} else {
+			failures++
+			if failures == deyeOutageAlertAfter {
+				notifiers.Broadcast(AlertInfo, localize("deye.lost"))
+			}
+		}
+
+		wait := withJitter(pollBackoff(interval, failures), cfg.PollJitterPercent)
+		if rejected == nil {
+			rejectedAlerted = false
+		} else if !rejectedAlerted {
+			alertDeyeRejected(bot, rejected)
+			rejectedAlerted = true
+		}
+		if rejected != nil && failures > 0 {
+			// Retrying soon won't help until someone fixes the config
+			wait = maxPollBackoff
+		} else if until := cfg.untilPollChange(time.Now()); failures == 0 && until > 0 {
+			// Switch to a new schedule window as soon as it starts
+			wait = min(wait, until)
+		}
+		if failures > 0 {
+			slog.Warn("[deye] Polling failed, backing off", "failures", failures, "next", wait)
+		}
+		timer := time.NewTimer(wait)
 		select {
 		case <-ctx.Done():
+			timer.Stop()
 			return
-		case <-ticker.C:
-			checkAndNotify()
+		case <-timer.C:
+		}
+	}
+}
+
+// alertDeyeRejected tells the admins that Deye refused a request in a way
+// only a configuration change can fix.
+func alertDeyeRejected(bot *TelegramBot, de *DeyeError) {
+	if de.Kind == DeyeErrCredentials {
+		slog.Error("[deye] Login rejected, check DEYE_EMAIL, DEYE_PASSWORD, DEYE_APP_ID and DEYE_APP_SECRET", "err", de)
+	} else {
+		slog.Error("[deye] Request rejected, check DEYE_STATION_ID, DEYE_DEVICE_SN and DEYE_STATIONS", "err", de)
+	}
+	for _, adminID := range bot.AdminIDs() {
+		l := bot.LangFor(adminID)
+		msg := l.tr("deye.rejected", html.EscapeString(de.Error()))
+		if de.Kind == DeyeErrCredentials {
+			msg = l.tr("deye.credentials")
 		}
+		if err := bot.SendMessage(adminID, msg); err != nil {
+			slog.Error("[telegram] Failed to alert admin", "chat", adminID, "err", err)
+		}
+	}
+}
+
+const (
+	// maxPollBackoff caps the poll interval while Deye Cloud is unreachable
+	maxPollBackoff = 15 * time.Minute
+	// deyeOutageAlertAfter is how many failed poll cycles in a row are
+	// announced as a lost connection
+	deyeOutageAlertAfter = 3
+	// batteryLowHysteresis is how far (percentage points) SOC must rise
+	// above BATTERY_LOW_SOC before the low battery alert is cleared
+	batteryLowHysteresis = 5.0
+)
+
+// pollBackoff doubles interval for every consecutive failure, up to maxPollBackoff.
+func pollBackoff(interval time.Duration, failures int) time.Duration {
+	if failures == 0 {
+		return interval
+	}
+	wait := interval
+	for i := 0; i < failures && wait < maxPollBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxPollBackoff)
+}
+
+// withJitter shifts d randomly by up to percent of it either way, so bots
+// started together don't keep hitting the API at the same moment.
+func withJitter(d time.Duration, percent int) time.Duration {
+	if percent <= 0 {
+		return d
+	}
+	spread := int64(d) * int64(percent) / 100
+	return d + time.Duration(mathrand.Int64N(2*spread+1)-spread)
+}
+
+// stationState is the poller's view of a single station.
+type stationState struct {
+	hasGrid     bool
+	deviceState int  // last known PowerStatus.DeviceState
+	batteryLow  bool // the low battery alert was sent and not yet cleared
+
+	// Grid change observed but not yet confirmed (debounce)
+	pending      bool
+	pendingSince time.Time
+}
+
+// confirmGrid reports whether hasGrid is a confirmed change of the grid
+// state, committing it if so. A new value must persist for at least
+// debounce before it is confirmed; flapping back resets the wait.
+func (s *stationState) confirmGrid(hasGrid bool, now time.Time, debounce time.Duration) bool {
+	if hasGrid == s.hasGrid {
+		s.pending = false
+		return false
+	}
+	if debounce > 0 {
+		if !s.pending {
+			s.pending = true
+			s.pendingSince = now
+			return false
+		}
+		if now.Sub(s.pendingSince) < debounce {
+			return false
+		}
+	}
+	s.pending = false
+	s.hasGrid = hasGrid
+	return true
+}
+
+// updateBatteryLow reports whether the battery just dropped below lowSOC
+// while off-grid, or recovered to lowSOC+batteryLowHysteresis after that.
+func (s *stationState) updateBatteryLow(hasGrid bool, soc, lowSOC float64) bool {
+	switch {
+	case !s.batteryLow && !hasGrid && soc < lowSOC:
+		s.batteryLow = true
+		return true
+	case s.batteryLow && soc >= lowSOC+batteryLowHysteresis:
+		s.batteryLow = false
+		return true
+	}
+	return false
+}
+
+func recordGridEvent(store *Storage, st Station, status *PowerStatus) {
+	if err := store.RecordGridEvent(st, time.Now(), status); err != nil {
+		slog.Error("[db] Failed to record grid event", "station", st.name(), "err", err)
+	}
+}
+
+// outageSince returns how long the grid has been off according to the
+// station's last recorded event, which survives restarts; 0 if unknown.
+func outageSince(store *Storage, st Station, now time.Time) time.Duration {
+	last, err := store.LastGridEvent(st)
+	if err != nil {
+		slog.Error("[db] Failed to get last grid event", "station", st.name(), "err", err)
+		return 0
 	}
+	if last == nil || last.HasGrid {
+		return 0
+	}
+	return now.Sub(last.Time)
 }
 
-func runTelegramPoller(ctx context.Context, deye *DeyeClient, bot *TelegramBot, cfg *Config, dtek *DtekClient) {
+func runTelegramPoller(ctx context.Context, app *App) {
+	// getUpdates fails while a webhook from an earlier run is set
+	if err := app.bot.DeleteWebhook(); err != nil {
+		slog.Error("[telegram] Failed to delete webhook", "err", err)
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -150,120 +653,381 @@ func runTelegramPoller(ctx context.Context, deye *DeyeClient, bot *TelegramBot,
 		default:
 		}
 
-		updates, err := bot.GetUpdates()
+		updates, err := app.bot.GetUpdates(ctx)
 		if err != nil {
-			log.Printf("[telegram] Failed to get updates: %v", err)
-			time.Sleep(5 * time.Second)
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("[telegram] Failed to get updates", "err", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
 			continue
 		}
 
 		for _, update := range updates {
-			if update.Message == nil {
-				continue
-			}
+			app.handleUpdate(ctx, update)
+		}
+	}
+}
 
-			chatID := update.Message.Chat.ID
+// runTelegramWebhook registers the webhook, retrying until Telegram accepts
+// it, and handles the updates the webhook server queues.
+func runTelegramWebhook(ctx context.Context, app *App, secret string, updates <-chan Update) {
+	for {
+		err := app.bot.SetWebhook(app.cfg.WebhookURL, secret)
+		if err == nil {
+			slog.Info("[telegram] Webhook set", "url", app.cfg.WebhookURL)
+			break
+		}
+		slog.Error("[telegram] Failed to set webhook", "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(30 * time.Second):
+		}
+	}
 
-			if !bot.IsAllowedUser(chatID) {
-				log.Printf("[telegram] Unauthorized user: %d", chatID)
-				continue
-			}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update := <-updates:
+			app.handleUpdate(ctx, update)
+		}
+	}
+}
 
-			switch update.Message.Text {
-			case "/status":
-				handleStatusCommand(deye, bot, cfg, chatID, dtek)
-			case "/start":
-				if err := bot.SendMessage(chatID, "Бот Світло активний. Використовуй /status щоб перевірити стан електрики."); err != nil {
-					log.Printf("[telegram] Failed to send /start reply: %v", err)
-				}
-			}
+// shutdownLine returns the DTEK schedule line, or "" when DTEK is disabled.
+func shutdownLine(ctx context.Context, l Lang, dtek ShutdownProvider) string {
+	if dtek == nil {
+		return ""
+	}
+	return dtek.ShutdownLine(ctx, l)
+}
+
+// optionalLine terminates a non-empty line with a newline.
+func optionalLine(line string) string {
+	if line == "" {
+		return ""
+	}
+	return line + "\n"
+}
+
+// withStationLabel prefixes a message with the station's label, if any.
+func withStationLabel(st Station, msg string) string {
+	if st.Label == "" {
+		return msg
+	}
+	return fmt.Sprintf("📍 <b>%s</b>\n%s", html.EscapeString(st.Label), msg)
+}
+
+// withStationLabels is withStationLabel for a message in every language.
+func withStationLabels(st Station, msg Localized) Localized {
+	return func(l Lang) string { return withStationLabel(st, msg(l)) }
+}
+
+// formatPowerOnMessage renders the power-on alert; outage is how long the
+// grid was off, 0 if unknown.
+func formatPowerOnMessage(l Lang, s *PowerStatus, dtekLine string, outage time.Duration) string {
+	data := newMessageData(l, s, dtekLine)
+	if outage > 0 {
+		data.Outage = formatDuration(l, outage)
+	}
+	return renderMessage(l, powerOnTemplate, data)
+}
+
+func formatPowerOffMessage(l Lang, s *PowerStatus, dtekLine string, capacityWh float64) string {
+	data := newMessageData(l, s, dtekLine)
+	data.Runtime = formatRuntime(l, s, capacityWh)
+	return renderMessage(l, powerOffTemplate, data)
+}
+
+func formatStatusMessage(l Lang, s *PowerStatus, dtekLine string) string {
+	return renderMessage(l, statusTemplate, newMessageData(l, s, dtekLine))
+}
+
+// formatRuntime estimates how long the battery lasts at the current
+// consumption, less what the panels generate.
+func formatRuntime(l Lang, s *PowerStatus, capacityWh float64) string {
+	if capacityWh <= 0 {
+		return l.tr("runtime.unknown")
+	}
+	load := s.ConsumptionPower - s.GenerationPower
+	if load <= 0 {
+		return l.tr("runtime.solar")
+	}
+	remainingWh := capacityWh * s.BatterySOC / 100
+	return "~" + formatDuration(l, hoursDuration(remainingWh/load))
+}
+
+// Acceptable grid ranges for a 230V/50Hz network
+const (
+	gridVoltageMin   = 200.0
+	gridVoltageMax   = 245.0
+	gridFrequencyMin = 49.5
+	gridFrequencyMax = 50.5
+)
+
+// formatGridQualityLine shows grid voltage and frequency, flagged with ⚠️
+// when out of range. Empty if the inverter reports neither.
+func formatGridQualityLine(l Lang, s *PowerStatus) string {
+	var parts []string
+	warn := false
+	if v := s.GridVoltage; v != nil {
+		parts = append(parts, fmt.Sprintf("%.0fV", *v))
+		warn = warn || *v < gridVoltageMin || *v > gridVoltageMax
+	}
+	if f := s.GridFrequency; f != nil {
+		parts = append(parts, fmt.Sprintf("%.1fHz", *f))
+		warn = warn || *f < gridFrequencyMin || *f > gridFrequencyMax
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+
+	line := l.tr("grid.quality", strings.Join(parts, ", "))
+	if warn {
+		line = l.tr("grid.out_of_range", line)
+	}
+	return line
+}
+
+// Deye device states
+const (
+	deviceStateOnline  = 1
+	deviceStateAlert   = 2
+	deviceStateOffline = 3
+)
+
+// deviceStateChangeKey is the message announcing an inverter going
+// offline, raising an alert, or recovering from either; it takes the time
+// of the change. Other transitions return "".
+func deviceStateChangeKey(prev, cur int) string {
+	switch cur {
+	case deviceStateOffline:
+		return "device.went_offline"
+	case deviceStateAlert:
+		return "device.raised_alarm"
+	case deviceStateOnline:
+		if prev == deviceStateOffline || prev == deviceStateAlert {
+			return "device.back_online"
 		}
 	}
+	return ""
 }
 
-func handleStatusCommand(deye *DeyeClient, bot *TelegramBot, cfg *Config, chatID int64, dtek *DtekClient) {
-	status, err := deye.GetPowerStatus(cfg.DeyeStationID, cfg.DeyeDeviceSN)
-	if err != nil {
-		log.Printf("[telegram] Failed to get status for /status command: %v", err)
-		if sendErr := bot.SendMessage(chatID, "Помилка при отриманні статусу. Спробуйте пізніше."); sendErr != nil {
-			log.Printf("[telegram] Failed to send error message: %v", sendErr)
+// formatAlarms lists alarms one per line with a description of each: ours
+// for known fault codes, else Deye's name for it.
+func formatAlarms(l Lang, alarms []DeviceAlarm) string {
+	lines := make([]string, 0, len(alarms))
+	for _, a := range alarms {
+		code := html.EscapeString(a.Code.key())
+		desc := html.EscapeString(cmp.Or(a.AlertName, a.Description))
+		if _, ok := bundles[defaultLang]["alarm."+code]; ok && code != "" {
+			desc = l.tr("alarm." + code)
+		}
+		switch {
+		case code == "" && desc == "":
+			continue
+		case code == "":
+			lines = append(lines, "⚠️ "+desc)
+		case desc == "":
+			lines = append(lines, "⚠️ "+code)
+		default:
+			lines = append(lines, "⚠️ "+code+" — "+desc)
 		}
-		return
 	}
+	return strings.Join(lines, "\n")
+}
+
+// formatRawDeviceData lists a device's data items one per line as
+// name = value unit.
+func formatRawDeviceData(l Lang, dev DeviceLatestEntry) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>🔧 %s</b>\n", html.EscapeString(dev.DeviceSn))
+	b.WriteString(l.tr("raw.state", dev.DeviceState, formatTime(float64(dev.CollectionTime))) + "\n\n")
+	for _, item := range dev.DataList {
+		line := item.Name + " = " + item.Value
+		if item.Unit != "" {
+			line += " " + item.Unit
+		}
+		b.WriteString(html.EscapeString(line) + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
 
-	msg := formatStatusMessage(status, dtek.ShutdownLine())
-	if err := bot.SendMessage(chatID, msg); err != nil {
-		log.Printf("[telegram] Failed to send status: %v", err)
-	}
-}
-
-func formatPowerOnMessage(s *PowerStatus, dtekLine string) string {
-	return fmt.Sprintf(
-		"<b>⚡ Світло З'ЯВИЛОСЬ!</b>\n\n"+
-			"🔌 Мережа: %.0fW\n"+
-			"🔋 Батарея: %.0f%%\n"+
-			"☀️ Генерація: %.0fW\n"+
-			"🏠 Споживання: %.0fW\n"+
-			"%s\n"+
-			"🕐 %s",
-		s.GridPower, s.BatterySOC,
-		s.GenerationPower, s.ConsumptionPower,
-		dtekLine,
-		formatTime(s.LastUpdateTime),
-	)
-}
-
-func formatPowerOffMessage(s *PowerStatus, dtekLine string) string {
-	return fmt.Sprintf(
-		"<b>❌ Світло ЗНИКЛО!</b>\n\n"+
-			"🔋 Батарея: %.0f%%\n"+
-			"☀️ Генерація: %.0fW\n"+
-			"🏠 Споживання: %.0fW\n"+
-			"%s\n"+
-			"🕐 %s",
-		s.BatterySOC,
-		s.GenerationPower, s.ConsumptionPower,
-		dtekLine,
-		formatTime(s.LastUpdateTime),
-	)
-}
-
-func formatStatusMessage(s *PowerStatus, dtekLine string) string {
-	gridStatus := "❌ Світла НЕМАЄ, але є добро"
-	if s.HasGrid {
-		gridStatus = "⚡ Світло Є, але нема добра((("
-	}
-
-	deviceStatus := "Офлайн"
-	switch s.DeviceState {
-	case 1:
-		deviceStatus = "Онлайн"
-	case 2:
-		deviceStatus = "Тривога"
-	case 3:
-		deviceStatus = "Офлайн"
-	}
-
-	batteryLine := fmt.Sprintf("🔋 Батарея: %.0f%% (%.0fW)", s.BatterySOC, s.BatteryPower)
+func formatDeviceList(l Lang, devices []DeviceListItem) string {
+	var b strings.Builder
+	b.WriteString(l.tr("devices.title", len(devices)))
+	for _, d := range devices {
+		status := l.tr("devices.status_unknown", d.ConnectStatus)
+		switch d.ConnectStatus {
+		case 0:
+			status = l.tr("devices.status_offline")
+		case 1:
+			status = l.tr("devices.status_online")
+		case 2:
+			status = l.tr("devices.status_alarm")
+		}
+		b.WriteString("\n\n" + l.tr("devices.entry",
+			html.EscapeString(d.DeviceSn), d.StationID, html.EscapeString(d.StationName),
+			html.EscapeString(d.DeviceType), html.EscapeString(d.ProductName), status))
+	}
+	return b.String()
+}
+
+func formatQuietSummaryLine(l Lang, t time.Time, hasGrid bool) string {
+	if hasGrid {
+		return l.tr("quiet.power_on", t.Format("15:04"))
+	}
+	return l.tr("quiet.power_off", t.Format("15:04"))
+}
+
+func formatBatteryMessage(l Lang, s *PowerStatus, capacityWh float64) string {
+	msg := l.tr("battery.title", s.BatterySOC) +
+		l.tr("battery.power", s.BatteryPower) +
+		l.tr("battery.charge", s.ChargePower) +
+		l.tr("battery.discharge", s.DischargePower)
 	if s.BatteryTemp != nil {
-		batteryLine += fmt.Sprintf(" %.0f°C", *s.BatteryTemp)
-	}
-
-	return fmt.Sprintf(
-		"<b>%s</b>\n\n"+
-			"☀️ Генерація: %.0fW\n"+
-			"🏠 Споживання: %.0fW\n"+
-			"%s\n"+
-			"📡 Пристрій: %s\n"+
-			"%s\n"+
-			"🕐 %s",
-		gridStatus,
-		s.GenerationPower, s.ConsumptionPower,
-		batteryLine,
-		deviceStatus,
-		dtekLine,
-		formatTime(s.LastUpdateTime),
-	)
+		msg += l.tr("battery.temperature", *s.BatteryTemp)
+	}
+
+	if capacityWh > 0 {
+		switch {
+		case s.ChargePower > 0:
+			remainingWh := capacityWh * (100 - s.BatterySOC) / 100
+			msg += l.tr("battery.until_full", formatDuration(l, hoursDuration(remainingWh/s.ChargePower)))
+		case s.DischargePower > 0:
+			remainingWh := capacityWh * s.BatterySOC / 100
+			msg += l.tr("battery.until_empty", formatDuration(l, hoursDuration(remainingWh/s.DischargePower)))
+		}
+	}
+
+	return msg + "🕐 " + formatTime(s.LastUpdateTime)
+}
+
+func formatForecastMessage(l Lang, shutdown *Shutdown) string {
+	if shutdown == nil {
+		return l.tr("forecast.title") + l.tr("forecast.none")
+	}
+
+	var b strings.Builder
+	b.WriteString(l.tr("forecast.title"))
+	if kind := shutdown.Kind(l); kind != "" {
+		b.WriteString(l.tr("forecast.kind", kind))
+	}
+	if desc := shutdown.Description(l); desc != "" {
+		fmt.Fprintf(&b, "%s\n", html.EscapeString(desc))
+	}
+	start, end := shutdown.Period()
+	fmt.Fprintf(&b, "🕐 %s – %s\n", html.EscapeString(start), html.EscapeString(end))
+	if c := shutdown.Countdown(l, time.Now()); c != "" {
+		b.WriteString(l.tr("forecast.countdown", c))
+	}
+	if len(shutdown.Reason) > 0 {
+		b.WriteString(l.tr("forecast.reasons"))
+		for _, r := range shutdown.Reason {
+			fmt.Fprintf(&b, "• %s\n", html.EscapeString(r))
+		}
+	}
+	if shutdown.Stale {
+		b.WriteString(l.tr("forecast.stale"))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// formatNextMessage answers /next for one station.
+func formatNextMessage(l Lang, hasGrid bool, shutdown *Shutdown, now time.Time) string {
+	if !hasGrid {
+		if shutdown != nil && !shutdown.End.IsZero() && now.Before(shutdown.End) {
+			return l.tr("next.off_until",
+				shutdown.End.In(time.Local).Format("15:04"), formatDuration(l, shutdown.End.Sub(now)))
+		}
+		return l.tr("next.off_unknown")
+	}
+
+	if shutdown != nil && !shutdown.Start.IsZero() && now.Before(shutdown.Start) {
+		msg := l.tr("next.on_until",
+			shutdown.Start.In(time.Local).Format("15:04"), formatDuration(l, shutdown.Start.Sub(now)))
+		if !shutdown.End.IsZero() {
+			msg += l.tr("next.outage_end", shutdown.End.In(time.Local).Format("15:04"))
+		}
+		return msg
+	}
+	return l.tr("next.on_none")
+}
+
+func formatHistoryMessage(l Lang, sum OutageSummary) string {
+	if sum.Count == 0 {
+		return l.tr("history.title") + l.tr("history.none")
+	}
+	return l.tr("history.title") +
+		l.tr("history.count", sum.Count) +
+		l.tr("history.total", formatDuration(l, sum.Total)) +
+		l.tr("history.longest", formatDuration(l, sum.Longest))
+}
+
+// statsTopHours is how many of the most outage-prone hours /stats names.
+const statsTopHours = 3
+
+// formatStatsMessage renders /stats: totals over statsDays and a bar per
+// hour of the day showing how much of it was spent without grid.
+func formatStatsMessage(l Lang, sum OutageSummary, byHour [24]time.Duration) string {
+	if sum.Count == 0 {
+		return l.tr("stats.title", statsDays) + l.tr("history.none")
+	}
+
+	var b strings.Builder
+	b.WriteString(l.tr("stats.title", statsDays))
+	b.WriteString(l.tr("stats.count", sum.Count, float64(sum.Count)/statsDays))
+	b.WriteString(l.tr("history.total", formatDuration(l, sum.Total)))
+	b.WriteString(l.tr("history.longest", formatDuration(l, sum.Longest)) + "\n")
+
+	hours := make([]int, 0, 24)
+	for h, d := range byHour {
+		if d > 0 {
+			hours = append(hours, h)
+		}
+	}
+	slices.SortStableFunc(hours, func(x, y int) int { return cmp.Compare(byHour[y], byHour[x]) })
+	top := make([]string, 0, statsTopHours)
+	for _, h := range hours[:min(len(hours), statsTopHours)] {
+		top = append(top, fmt.Sprintf("%02d:00", h))
+	}
+	b.WriteString(l.tr("stats.top_hours", strings.Join(top, ", ")))
+
+	// Share of each hour slot spent without grid over the window
+	const barWidth = 10
+	b.WriteString("<pre>")
+	for h, d := range byHour {
+		share := float64(d) / float64(statsDays*time.Hour)
+		filled := int(math.Round(share * barWidth))
+		if h > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "%02d %s%s %3.0f%%", h,
+			strings.Repeat("█", filled), strings.Repeat("░", barWidth-filled), share*100)
+	}
+	b.WriteString("</pre>")
+	return b.String()
+}
+
+func hoursDuration(h float64) time.Duration {
+	return time.Duration(h * float64(time.Hour))
+}
+
+// formatDuration renders a duration as e.g. "2 год 15 хв".
+func formatDuration(l Lang, d time.Duration) string {
+	d = d.Round(time.Minute)
+	h := int(d / time.Hour)
+	m := int((d % time.Hour) / time.Minute)
+	if h == 0 {
+		return l.tr("duration.minutes", m)
+	}
+	return l.tr("duration.hours", h, m)
 }
 
 func formatTime(ts float64) string {
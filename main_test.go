@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubPower returns the same status on every poll.
+type stubPower struct{ status PowerStatus }
+
+func (p stubPower) GetPowerStatus(ctx context.Context, stationID int64, deviceSN string) (*PowerStatus, error) {
+	s := p.status
+	return &s, nil
+}
+
+// recordingNotifier keeps the broadcast messages and calls onBroadcast.
+type recordingNotifier struct {
+	mu          sync.Mutex
+	messages    []string
+	onBroadcast func()
+}
+
+func (n *recordingNotifier) Broadcast(kind AlertKind, msg Localized) {
+	n.mu.Lock()
+	n.messages = append(n.messages, msg(LangEN))
+	n.mu.Unlock()
+	n.onBroadcast()
+}
+
+func TestPollerStaleFirstReading(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "svitlo.db"))
+	if err != nil {
+		t.Fatalf("NewStorage: %v", err)
+	}
+	defer store.Close()
+
+	st := Station{ID: 1, DeviceSN: "SN001"}
+	cfg := &Config{
+		Stations:          []Station{st},
+		PollIntervalSec:   60,
+		DeyeStaleAfter:    time.Hour,
+		DryRun:            true,
+		TelegramRateLimit: 30,
+	}
+	updated := time.Now().Add(-3 * time.Hour)
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+	notifier := &recordingNotifier{onBroadcast: cancel}
+	app := &App{
+		cfg:       cfg,
+		power:     stubPower{PowerStatus{HasGrid: true, DeviceOnline: true, LastUpdateTime: float64(updated.Unix())}},
+		bot:       NewTelegramBot(cfg, nil),
+		notifiers: notifier,
+		store:     store,
+		metrics:   NewMetrics(),
+		health:    NewHealth(time.Minute),
+	}
+
+	runPowerPoller(ctx, app)
+
+	if len(notifier.messages) != 1 {
+		t.Fatalf("broadcast %q, want only the stale data alert", notifier.messages)
+	}
+	if want := LangEN.tr("deye.stale", formatTime(float64(updated.Unix()))); notifier.messages[0] != want {
+		t.Errorf("alert = %q, want %q", notifier.messages[0], want)
+	}
+	if e, err := store.LastGridEvent(st); err != nil || e != nil {
+		t.Errorf("LastGridEvent = %+v, %v; want no event recorded from stale data", e, err)
+	}
+}
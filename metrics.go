@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics holds the latest per-station readings and exposes them in the
+// Prometheus text format.
+type Metrics struct {
+	mu         sync.Mutex
+	stations   map[string]*PowerStatus // keyed by station name
+	pollErrors map[string]uint64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		stations:   make(map[string]*PowerStatus),
+		pollErrors: make(map[string]uint64),
+	}
+}
+
+func (m *Metrics) Observe(st Station, status *PowerStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stations[st.name()] = status
+}
+
+func (m *Metrics) PollError(st Station) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pollErrors[st.name()]++
+}
+
+type metricDef struct {
+	name  string
+	help  string
+	value func(s *PowerStatus) float64
+}
+
+var gaugeDefs = []metricDef{
+	{"svitlo_grid_present", "Whether grid power is present (1) or not (0).", func(s *PowerStatus) float64 {
+		if s.HasGrid {
+			return 1
+		}
+		return 0
+	}},
+	{"svitlo_battery_soc", "Battery state of charge, percent.", func(s *PowerStatus) float64 { return s.BatterySOC }},
+	{"svitlo_generation_watts", "PV generation power, watts.", func(s *PowerStatus) float64 { return s.GenerationPower }},
+	{"svitlo_consumption_watts", "Household consumption power, watts.", func(s *PowerStatus) float64 { return s.ConsumptionPower }},
+	{"svitlo_grid_watts", "Grid power, watts.", func(s *PowerStatus) float64 { return s.GridPower }},
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	names := make([]string, 0, len(m.stations))
+	for name := range m.stations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, def := range gaugeDefs {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", def.name, def.help, def.name)
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s{station=%q} %g\n", def.name, name, def.value(m.stations[name]))
+		}
+	}
+
+	errNames := make([]string, 0, len(m.pollErrors))
+	for name := range m.pollErrors {
+		errNames = append(errNames, name)
+	}
+	sort.Strings(errNames)
+
+	b.WriteString("# HELP svitlo_poll_errors_total Failed Deye polls.\n# TYPE svitlo_poll_errors_total counter\n")
+	for _, name := range errNames {
+		fmt.Fprintf(&b, "svitlo_poll_errors_total{station=%q} %d\n", name, m.pollErrors[name])
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// runHTTPServer serves handler on addr until ctx is cancelled.
+func runHTTPServer(ctx context.Context, addr string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("[http] Shutdown failed", "addr", addr, "err", err)
+		}
+	}()
+
+	slog.Info("[http] Listening", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("[http] Server failed", "addr", addr, "err", err)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Notifier is a sink for automatic alerts. Messages are written in the
+// Telegram HTML subset; other sinks convert them as needed.
+type Notifier interface {
+	Broadcast(kind AlertKind, msg Localized)
+}
+
+// Localized renders a message in the given language, so that every chat
+// can get alerts in the language it chose.
+type Localized func(l Lang) string
+
+// localize is the Localized form of a bundle message.
+func localize(key string, args ...any) Localized {
+	return func(l Lang) string { return l.tr(key, args...) }
+}
+
+// cached renders msg at most once per language.
+func (msg Localized) cached() Localized {
+	var mu sync.Mutex
+	texts := make(map[Lang]string)
+	return func(l Lang) string {
+		mu.Lock()
+		defer mu.Unlock()
+		text, ok := texts[l]
+		if !ok {
+			text = msg(l)
+			texts[l] = text
+		}
+		return text
+	}
+}
+
+// Notifiers fans an alert out to every configured sink.
+type Notifiers []Notifier
+
+func (ns Notifiers) Broadcast(kind AlertKind, msg Localized) {
+	msg = msg.cached()
+	for _, n := range ns {
+		n.Broadcast(kind, msg)
+	}
+}
+
+// dedupedNotifier drops a broadcast identical to the previous one sent
+// within window, e.g. a repeated power-on alert after the grid detection
+// flipped on stale data. It backs up GRID_DEBOUNCE_SEC rather than
+// replacing it.
+type dedupedNotifier struct {
+	Notifier
+	window time.Duration
+
+	mu     sync.Mutex
+	last   string
+	lastAt time.Time
+}
+
+func newDedupedNotifier(n Notifier, window time.Duration) *dedupedNotifier {
+	return &dedupedNotifier{Notifier: n, window: window}
+}
+
+func (d *dedupedNotifier) Broadcast(kind AlertKind, msg Localized) {
+	msg = msg.cached()
+	text := msg(activeLang)
+	now := time.Now()
+
+	d.mu.Lock()
+	dup := text == d.last && now.Sub(d.lastAt) < d.window
+	if !dup {
+		d.last, d.lastAt = text, now
+	}
+	d.mu.Unlock()
+
+	if dup {
+		slog.Warn("[notify] Skipping duplicate broadcast", "window", d.window, "text", text)
+		return
+	}
+	d.Notifier.Broadcast(kind, msg)
+}
+
+// globallyMuted drops alerts to a sink without per-chat settings, such as
+// Discord, while an admin's /mute all is in effect.
+type globallyMuted struct {
+	Notifier
+	settings *SettingsStore
+}
+
+func (g globallyMuted) Broadcast(kind AlertKind, msg Localized) {
+	if until := g.settings.MutedUntil(allChats, time.Now()); !until.IsZero() {
+		slog.Info("[notify] Alerts muted, skipping broadcast", "until", until.Format("15:04"))
+		return
+	}
+	g.Notifier.Broadcast(kind, msg)
+}
+
+var htmlTagRe = regexp.MustCompile(`<[^>]*>`)
+
+// stripHTML turns a Telegram HTML message into plain text.
+func stripHTML(s string) string {
+	return html.UnescapeString(htmlTagRe.ReplaceAllString(s, ""))
+}
+
+// --- Discord ---
+
+// discordMaxContent is the webhook limit on message length, in characters.
+const discordMaxContent = 2000
+
+var discordTags = map[string]string{
+	"b": "**", "/b": "**",
+	"i": "*", "/i": "*",
+	"code": "`", "/code": "`",
+}
+
+var discordEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "|", `\|`, ">", `\>`,
+)
+
+// discordMarkdown converts a Telegram HTML message to Discord markdown:
+// supported tags become markdown, others are dropped, and text that
+// markdown would interpret is escaped.
+func discordMarkdown(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range htmlTagRe.FindAllStringIndex(s, -1) {
+		b.WriteString(discordEscaper.Replace(html.UnescapeString(s[last:loc[0]])))
+		name := strings.ToLower(strings.TrimSpace(s[loc[0]+1 : loc[1]-1]))
+		if i := strings.IndexByte(name, ' '); i >= 0 {
+			name = name[:i]
+		}
+		b.WriteString(discordTags[name])
+		last = loc[1]
+	}
+	b.WriteString(discordEscaper.Replace(html.UnescapeString(s[last:])))
+	return truncateRunes(b.String(), discordMaxContent)
+}
+
+// truncateRunes cuts s to at most n characters, marking the cut with "…".
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:n-1]) + "…"
+}
+
+// DiscordNotifier posts alerts to a Discord channel webhook.
+type DiscordNotifier struct {
+	webhookURL string
+	httpClient *http.Client
+}
+
+func NewDiscordNotifier(webhookURL string, proxy *url.URL) *DiscordNotifier {
+	return &DiscordNotifier{
+		webhookURL: webhookURL,
+		httpClient: newHTTPClient(30*time.Second, proxy),
+	}
+}
+
+type discordMessage struct {
+	Content         string                 `json:"content"`
+	AllowedMentions discordAllowedMentions `json:"allowed_mentions"`
+}
+
+// discordAllowedMentions with an empty Parse keeps "@everyone" in a message
+// from pinging anyone.
+type discordAllowedMentions struct {
+	Parse []string `json:"parse"`
+}
+
+func (d *DiscordNotifier) Broadcast(kind AlertKind, msg Localized) {
+	if err := d.send(discordMarkdown(msg(activeLang))); err != nil {
+		slog.Error("[discord] Failed to send", "err", err)
+	}
+}
+
+func (d *DiscordNotifier) send(content string) error {
+	data, err := json.Marshal(discordMessage{
+		Content:         content,
+		AllowedMentions: discordAllowedMentions{Parse: []string{}},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+
+	resp, err := d.httpClient.Post(d.webhookURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook returned %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// --- ntfy ---
+
+// NtfyNotifier publishes alerts to an ntfy topic as plain text.
+type NtfyNotifier struct {
+	topicURL   string
+	token      string // bearer token for private servers; may be empty
+	httpClient *http.Client
+}
+
+func NewNtfyNotifier(serverURL, topic, token string, proxy *url.URL) *NtfyNotifier {
+	return &NtfyNotifier{
+		topicURL:   strings.TrimRight(serverURL, "/") + "/" + url.PathEscape(topic),
+		token:      token,
+		httpClient: newHTTPClient(30*time.Second, proxy),
+	}
+}
+
+// ntfyHeaders maps an alert kind to the ntfy priority and tags (tags
+// matching an emoji name are shown as that emoji).
+func ntfyHeaders(kind AlertKind) (priority, tags string) {
+	switch kind {
+	case AlertPowerOn:
+		return "high", "zap"
+	case AlertPowerOff:
+		return "default", "warning"
+	}
+	return "default", ""
+}
+
+func (n *NtfyNotifier) Broadcast(kind AlertKind, msg Localized) {
+	if err := n.publish(kind, stripHTML(msg(activeLang))); err != nil {
+		slog.Error("[ntfy] Failed to publish", "err", err)
+	}
+}
+
+func (n *NtfyNotifier) publish(kind AlertKind, message string) error {
+	req, err := http.NewRequest("POST", n.topicURL, strings.NewReader(message))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	priority, tags := ntfyHeaders(kind)
+	req.Header.Set("Priority", priority)
+	if tags != "" {
+		req.Header.Set("Tags", tags)
+	}
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("post %s: %w", n.topicURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("ntfy returned %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
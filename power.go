@@ -0,0 +1,11 @@
+package main
+
+import "context"
+
+// PowerSource supplies inverter readings to the poller and the status
+// commands. DeyeClient reads them from Deye Cloud, HomeAssistantSource from
+// a local Home Assistant and ReplaySource from a recording.
+type PowerSource interface {
+	// GetPowerStatus returns the current readings of the station's inverter.
+	GetPowerStatus(ctx context.Context, stationID int64, deviceSN string) (*PowerStatus, error)
+}
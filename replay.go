@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// replayEntry is one recorded poll: the raw station/latest and device/latest
+// responses, as logged with LOG_LEVEL=debug.
+type replayEntry struct {
+	Station StationLatestResponse `json:"station"`
+	Device  DeviceLatestResponse  `json:"device"`
+}
+
+// ReplaySource plays back recorded Deye responses instead of calling the
+// API, one entry per GetPowerStatus call, so alert logic can be exercised
+// deterministically. After the last entry it keeps returning that one.
+type ReplaySource struct {
+	th GridThresholds
+
+	mu      sync.Mutex
+	entries []replayEntry
+	next    int
+	done    bool               // the last entry has been played
+	prevSOC map[string]float64 // keyed like the Deye status cache
+}
+
+// NewReplaySource reads a sequence of JSON entries, e.g. one per line, from
+// path.
+func NewReplaySource(path string, th GridThresholds) (*ReplaySource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open replay file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []replayEntry
+	dec := json.NewDecoder(f)
+	for {
+		var e replayEntry
+		if err := dec.Decode(&e); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("parse replay entry %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("replay file %s has no entries", path)
+	}
+
+	return &ReplaySource{th: th, entries: entries, prevSOC: make(map[string]float64)}, nil
+}
+
+func (r *ReplaySource) GetPowerStatus(ctx context.Context, stationID int64, deviceSN string) (*PowerStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.next
+	e := r.entries[i]
+	if i < len(r.entries)-1 {
+		r.next++
+	} else if !r.done {
+		slog.Info("[replay] Reached the last entry, repeating it", "entries", len(r.entries))
+		r.done = true
+	}
+
+	key := fmt.Sprintf("%d:%s", stationID, deviceSN)
+	var prevSOC *float64
+	if soc, ok := r.prevSOC[key]; ok {
+		prevSOC = &soc
+	}
+	status, reason := buildPowerStatus(&e.Station, &e.Device, prevSOC, r.th)
+	r.prevSOC[key] = status.BatterySOC
+	slog.Debug("[replay] Entry", "index", i, "station", stationID, "hasGrid", status.HasGrid, "reason", reason)
+	return status, nil
+}
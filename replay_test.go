@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestReplaySource(t *testing.T) {
+	r, err := NewReplaySource("testdata/flapping.jsonl", GridThresholds{})
+	if err != nil {
+		t.Fatalf("NewReplaySource: %v", err)
+	}
+
+	// The last entry repeats once the recording runs out
+	want := []bool{true, false, false, true, false, false, false}
+	for i, w := range want {
+		status, err := r.GetPowerStatus(t.Context(), 1, "SN001")
+		if err != nil {
+			t.Fatalf("poll %d: %v", i, err)
+		}
+		if status.HasGrid != w {
+			t.Errorf("poll %d: HasGrid = %v, want %v", i, status.HasGrid, w)
+		}
+		if !status.DeviceOnline {
+			t.Errorf("poll %d: DeviceOnline = false, want true", i)
+		}
+	}
+}
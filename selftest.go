@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// selfTestTimeout bounds a single self-test step; DTEK needs the most, as
+// it may launch Chromium and retry.
+const selfTestTimeout = 3 * time.Minute
+
+// selfTestStep is one integration check of --selftest.
+type selfTestStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// selfTestResult is the outcome of a step.
+type selfTestResult struct {
+	name     string
+	err      error
+	duration time.Duration
+}
+
+// runSelfTest runs every configured integration once: Deye (or the
+// replay file or Home Assistant that replaces it), DTEK and a test message
+// to the admins. It prints a pass/fail line per step to w and reports
+// whether all passed.
+func runSelfTest(ctx context.Context, cfg *Config, w io.Writer) bool {
+	var steps []selfTestStep
+	stations := cfg.Stations
+	if len(stations) == 0 {
+		stations = []Station{{ID: cfg.DeyeStationID, DeviceSN: cfg.DeyeDeviceSN}}
+	}
+
+	switch {
+	case cfg.DeyeReplayFile != "":
+		steps = append(steps, selfTestStep{"Replay file", func(ctx context.Context) error {
+			replay, err := NewReplaySource(cfg.DeyeReplayFile, cfg.GridThresholds)
+			if err != nil {
+				return err
+			}
+			_, err = replay.GetPowerStatus(ctx, stations[0].ID, stations[0].DeviceSN)
+			return err
+		}})
+	case cfg.HAURL != "":
+		steps = append(steps, selfTestStep{"Home Assistant", func(ctx context.Context) error {
+			_, err := NewHomeAssistantSource(cfg).GetPowerStatus(ctx, stations[0].ID, stations[0].DeviceSN)
+			return err
+		}})
+	default:
+		deye := NewDeyeClient(cfg)
+		steps = append(steps,
+			selfTestStep{"Deye authentication", deye.Authenticate},
+			selfTestStep{"Deye device list", func(ctx context.Context) error {
+				devices, err := deye.GetDeviceList(ctx)
+				if err != nil {
+					return err
+				}
+				if len(devices.Devices) == 0 {
+					return errors.New("no devices on the account")
+				}
+				// Discovery as at startup
+				if stations[0].ID == 0 {
+					stations[0].ID = devices.Devices[0].StationID
+				}
+				if stations[0].DeviceSN == "" {
+					stations[0].DeviceSN = devices.Devices[0].DeviceSn
+				}
+				return nil
+			}},
+		)
+		for i := range stations {
+			suffix := ""
+			if len(stations) > 1 {
+				suffix = " " + stations[i].name()
+			}
+			steps = append(steps,
+				selfTestStep{"Deye station data" + suffix, func(ctx context.Context) error {
+					_, err := deye.GetStationLatest(ctx, stations[i].ID)
+					return err
+				}},
+				selfTestStep{"Deye device data" + suffix, func(ctx context.Context) error {
+					resp, err := deye.GetDeviceLatest(ctx, []string{stations[i].DeviceSN})
+					if err != nil {
+						return err
+					}
+					if len(resp.DeviceList) == 0 {
+						return errors.New("no data for the device")
+					}
+					return nil
+				}},
+			)
+		}
+	}
+
+	if cfg.DtekEnabled {
+		steps = append(steps, selfTestStep{"DTEK schedule (" + cfg.Provider + ")", func(ctx context.Context) error {
+			dtek, err := NewShutdownProvider(cfg)
+			if err != nil {
+				return err
+			}
+			defer dtek.Close()
+			_, err = dtek.GetShutdown(ctx)
+			return err
+		}})
+	}
+
+	bot := NewTelegramBot(cfg, nil)
+	steps = append(steps,
+		selfTestStep{"Telegram bot", func(ctx context.Context) error {
+			_, err := bot.GetMe()
+			return err
+		}},
+		selfTestStep{"Telegram message", func(ctx context.Context) error {
+			chatIDs := cfg.AdminUserIDs
+			if len(chatIDs) == 0 {
+				chatIDs = cfg.TelegramUserIDs
+			}
+			var errs []error
+			for _, chatID := range chatIDs {
+				if err := bot.SendMessage(chatID, bot.LangFor(chatID).tr("selftest.message")); err != nil {
+					errs = append(errs, fmt.Errorf("chat %d: %w", chatID, err))
+				}
+			}
+			return errors.Join(errs...)
+		}},
+	)
+
+	ok := true
+	for _, step := range steps {
+		r := runSelfTestStep(ctx, step)
+		fmt.Fprintln(w, r)
+		if r.err != nil {
+			ok = false
+		}
+	}
+	return ok
+}
+
+func runSelfTestStep(ctx context.Context, step selfTestStep) selfTestResult {
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := step.run(ctx)
+	return selfTestResult{name: step.name, err: err, duration: time.Since(start)}
+}
+
+func (r selfTestResult) String() string {
+	took := r.duration.Round(time.Millisecond)
+	if r.err != nil {
+		return fmt.Sprintf("FAIL  %-30s %8s  %v", r.name, took, r.err)
+	}
+	return fmt.Sprintf("PASS  %-30s %8s", r.name, took)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// NotifyPref selects which automatic alerts a chat receives.
+type NotifyPref string
+
+const (
+	NotifyAll      NotifyPref = "all"
+	NotifyPowerOn  NotifyPref = "poweron"
+	NotifyPowerOff NotifyPref = "poweroff"
+	NotifyNone     NotifyPref = "none"
+)
+
+func parseNotifyPref(s string) (NotifyPref, bool) {
+	switch p := NotifyPref(s); p {
+	case NotifyAll, NotifyPowerOn, NotifyPowerOff, NotifyNone:
+		return p, true
+	}
+	return "", false
+}
+
+// AlertKind classifies a broadcast so it can be filtered per chat.
+type AlertKind int
+
+const (
+	AlertInfo         AlertKind = iota // summaries and other non-grid messages
+	AlertPowerOn                       // grid came back
+	AlertPowerOff                      // grid went away
+	AlertQuietSummary                  // grid changes held back during quiet hours
+)
+
+// Wants reports whether a chat with this preference receives kind.
+func (p NotifyPref) Wants(kind AlertKind) bool {
+	switch p {
+	case NotifyNone:
+		return false
+	case NotifyPowerOn:
+		return kind != AlertPowerOff
+	case NotifyPowerOff:
+		return kind != AlertPowerOn
+	}
+	return true
+}
+
+// ChatSettings are the per-chat preferences changeable from Telegram.
+type ChatSettings struct {
+	Notify NotifyPref `json:"notify,omitempty"`
+	// Lang is the language chosen in /settings; empty means SVITLO_LANG
+	Lang Lang `json:"lang,omitempty"`
+	// IgnoreQuietHours delivers grid alerts immediately during QUIET_HOURS
+	// instead of in the summary afterwards
+	IgnoreQuietHours bool `json:"ignore_quiet_hours,omitempty"`
+	// Subscriber marks a chat outside TELEGRAM_USER_IDS whose /subscribe
+	// request an admin approved
+	Subscriber bool `json:"subscriber,omitempty"`
+	// MutedUntil silences automatic alerts to the chat until then (/mute)
+	MutedUntil time.Time `json:"muted_until,omitzero"`
+	// Blocked marks a chat that blocked the bot; broadcasts skip it until it
+	// writes to the bot again
+	Blocked bool `json:"blocked,omitempty"`
+}
+
+// allChats is the settings entry of an admin's /mute all, which applies to
+// every chat and alert sink. Only its MutedUntil is used; Telegram never
+// assigns chat ID 0.
+const allChats int64 = 0
+
+// SettingsStore keeps ChatSettings in a JSON file.
+type SettingsStore struct {
+	path string
+
+	mu    sync.Mutex
+	chats map[int64]ChatSettings
+}
+
+// LoadSettings reads the settings file; a missing file yields empty settings.
+func LoadSettings(path string) (*SettingsStore, error) {
+	s := &SettingsStore{path: path, chats: make(map[int64]ChatSettings)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &s.chats); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Get returns the chat's settings with defaults filled in.
+func (s *SettingsStore) Get(chatID int64) ChatSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cs := s.chats[chatID]
+	if cs.Notify == "" {
+		cs.Notify = NotifyAll
+	}
+	if cs.Lang == "" {
+		cs.Lang = activeLang
+	}
+	return cs
+}
+
+// MutedUntil returns when the chat's mute or the global one ends, whichever
+// is later, or the zero time if neither is in effect at now.
+func (s *SettingsStore) MutedUntil(chatID int64, now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until := s.chats[chatID].MutedUntil
+	if global := s.chats[allChats].MutedUntil; global.After(until) {
+		until = global
+	}
+	if !until.After(now) {
+		return time.Time{}
+	}
+	return until
+}
+
+// Subscribers returns the approved subscriber chats in ascending order.
+func (s *SettingsStore) Subscribers() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []int64
+	for id, cs := range s.chats {
+		if cs.Subscriber {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Update applies fn to the chat's settings and saves the file.
+func (s *SettingsStore) Update(chatID int64, fn func(cs *ChatSettings)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cs := s.chats[chatID]
+	fn(&cs)
+	s.chats[chatID] = cs
+
+	data, err := json.MarshalIndent(s.chats, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal settings: %w", err)
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("write %s: %w", s.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ShutdownProvider supplies the planned outage schedule for the configured
+// address from a regional power distributor.
+type ShutdownProvider interface {
+	// GetShutdown returns the current or next outage, or nil if none is scheduled.
+	GetShutdown(ctx context.Context) (*Shutdown, error)
+	// ShutdownLine is a one-line summary for status messages.
+	ShutdownLine(ctx context.Context, l Lang) string
+	// ClearCache forces the next call to fetch fresh data.
+	ClearCache()
+	// CachedAt is when the schedule was last fetched, zero if never.
+	CachedAt() time.Time
+	// Close releases resources such as a headless browser.
+	Close()
+}
+
+// dtekSites are the DTEK subsidiaries sharing the same shutdowns API,
+// selected with PROVIDER.
+var dtekSites = map[string]string{
+	"dtek-dnipro":      "https://www.dtek-dnem.com.ua",
+	"dtek-kyiv-region": "https://www.dtek-krem.com.ua",
+	"dtek-kyiv":        "https://www.dtek-kem.com.ua",
+	"dtek-odesa":       "https://www.dtek-oem.com.ua",
+}
+
+func providerNames() string {
+	names := make([]string, 0, len(dtekSites))
+	for name := range dtekSites {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// NewShutdownProvider creates the provider named by cfg.Provider.
+func NewShutdownProvider(cfg *Config) (ShutdownProvider, error) {
+	if baseURL, ok := dtekSites[cfg.Provider]; ok {
+		return NewDtekProvider(cfg, baseURL), nil
+	}
+	return nil, fmt.Errorf("unknown provider %q, expected one of: %s", cfg.Provider, providerNames())
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Storage keeps grid state transitions in a local SQLite database.
+type Storage struct {
+	db *sql.DB
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+const storageSchema = `
+CREATE TABLE IF NOT EXISTS grid_events (
+	id                INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts                INTEGER NOT NULL, -- unix seconds
+	station           TEXT    NOT NULL, -- Station.key()
+	has_grid          INTEGER NOT NULL,
+	grid_power        REAL    NOT NULL,
+	purchase_power    REAL    NOT NULL,
+	generation_power  REAL    NOT NULL,
+	consumption_power REAL    NOT NULL,
+	battery_soc       REAL    NOT NULL,
+	battery_power     REAL    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_grid_events_station_ts ON grid_events (station, ts);
+
+CREATE TABLE IF NOT EXISTS samples (
+	ts          INTEGER NOT NULL, -- unix seconds
+	station     TEXT    NOT NULL, -- Station.key()
+	has_grid    INTEGER NOT NULL,
+	battery_soc REAL    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_samples_station_ts ON samples (station, ts);
+`
+
+// sampleRetention is how long per-poll samples are kept
+const sampleRetention = 30 * 24 * time.Hour
+
+func NewStorage(path string) (*Storage, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	// SQLite allows a single writer; serialize access instead of handling SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(storageSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init schema: %w", err)
+	}
+
+	return &Storage{db: db}, nil
+}
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// GridEvent is a recorded grid state change with the metrics at that moment.
+type GridEvent struct {
+	Time             time.Time
+	Station          string
+	HasGrid          bool
+	GridPower        float64
+	PurchasePower    float64
+	GenerationPower  float64
+	ConsumptionPower float64
+	BatterySOC       float64
+	BatteryPower     float64
+}
+
+func (s *Storage) RecordGridEvent(st Station, at time.Time, status *PowerStatus) error {
+	_, err := s.db.Exec(`
+		INSERT INTO grid_events (ts, station, has_grid, grid_power, purchase_power,
+			generation_power, consumption_power, battery_soc, battery_power)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		at.Unix(), st.key(), status.HasGrid, status.GridPower, status.PurchasePower,
+		status.GenerationPower, status.ConsumptionPower, status.BatterySOC, status.BatteryPower,
+	)
+	if err != nil {
+		return fmt.Errorf("insert grid event: %w", err)
+	}
+	return nil
+}
+
+// LastGridEvent returns the station's most recent event, or nil if there
+// is none.
+func (s *Storage) LastGridEvent(st Station) (*GridEvent, error) {
+	var e GridEvent
+	var ts int64
+	err := s.db.QueryRow(`
+		SELECT ts, station, has_grid, grid_power, purchase_power,
+			generation_power, consumption_power, battery_soc, battery_power
+		FROM grid_events
+		WHERE station = ?
+		ORDER BY ts DESC, id DESC
+		LIMIT 1`,
+		st.key(),
+	).Scan(&ts, &e.Station, &e.HasGrid, &e.GridPower, &e.PurchasePower,
+		&e.GenerationPower, &e.ConsumptionPower, &e.BatterySOC, &e.BatteryPower)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query last grid event: %w", err)
+	}
+	e.Time = time.Unix(ts, 0)
+	return &e, nil
+}
+
+// GridEventsSince returns the station's events after since, preceded by the
+// last event before since (if any) so callers know the state at the start.
+func (s *Storage) GridEventsSince(st Station, since time.Time) ([]GridEvent, error) {
+	rows, err := s.db.Query(`
+		SELECT ts, station, has_grid, grid_power, purchase_power,
+			generation_power, consumption_power, battery_soc, battery_power
+		FROM grid_events
+		WHERE station = ?1 AND ts >= COALESCE(
+			(SELECT MAX(ts) FROM grid_events WHERE station = ?1 AND ts < ?2), ?2)
+		ORDER BY ts, id`,
+		st.key(), since.Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query grid events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []GridEvent
+	for rows.Next() {
+		var e GridEvent
+		var ts int64
+		if err := rows.Scan(&ts, &e.Station, &e.HasGrid, &e.GridPower, &e.PurchasePower,
+			&e.GenerationPower, &e.ConsumptionPower, &e.BatterySOC, &e.BatteryPower); err != nil {
+			return nil, fmt.Errorf("scan grid event: %w", err)
+		}
+		e.Time = time.Unix(ts, 0)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// GridEventsBetween returns events of all stations in [from, to), oldest
+// first. A zero from or to leaves that end of the range open.
+func (s *Storage) GridEventsBetween(from, to time.Time) ([]GridEvent, error) {
+	var fromTS, toTS int64 = 0, math.MaxInt64
+	if !from.IsZero() {
+		fromTS = from.Unix()
+	}
+	if !to.IsZero() {
+		toTS = to.Unix()
+	}
+	rows, err := s.db.Query(`
+		SELECT ts, station, has_grid, grid_power, purchase_power,
+			generation_power, consumption_power, battery_soc, battery_power
+		FROM grid_events
+		WHERE ts >= ? AND ts < ?
+		ORDER BY ts, id`,
+		fromTS, toTS,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query grid events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []GridEvent
+	for rows.Next() {
+		var e GridEvent
+		var ts int64
+		if err := rows.Scan(&ts, &e.Station, &e.HasGrid, &e.GridPower, &e.PurchasePower,
+			&e.GenerationPower, &e.ConsumptionPower, &e.BatterySOC, &e.BatteryPower); err != nil {
+			return nil, fmt.Errorf("scan grid event: %w", err)
+		}
+		e.Time = time.Unix(ts, 0)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Sample is a single poll result used for charts.
+type Sample struct {
+	Time       time.Time
+	HasGrid    bool
+	BatterySOC float64
+}
+
+// RecordSample stores a poll result, pruning samples older than
+// sampleRetention at most once an hour.
+func (s *Storage) RecordSample(st Station, at time.Time, status *PowerStatus) error {
+	_, err := s.db.Exec(`INSERT INTO samples (ts, station, has_grid, battery_soc) VALUES (?, ?, ?, ?)`,
+		at.Unix(), st.key(), status.HasGrid, status.BatterySOC)
+	if err != nil {
+		return fmt.Errorf("insert sample: %w", err)
+	}
+
+	s.mu.Lock()
+	prune := time.Since(s.lastPruned) > time.Hour
+	if prune {
+		s.lastPruned = time.Now()
+	}
+	s.mu.Unlock()
+
+	if prune {
+		if _, err := s.db.Exec(`DELETE FROM samples WHERE ts < ?`, at.Add(-sampleRetention).Unix()); err != nil {
+			slog.Error("[db] Failed to prune samples", "err", err)
+		}
+	}
+	return nil
+}
+
+func (s *Storage) SamplesSince(st Station, since time.Time) ([]Sample, error) {
+	rows, err := s.db.Query(`
+		SELECT ts, has_grid, battery_soc FROM samples
+		WHERE station = ? AND ts >= ?
+		ORDER BY ts`,
+		st.key(), since.Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("query samples: %w", err)
+	}
+	defer rows.Close()
+
+	var samples []Sample
+	for rows.Next() {
+		var smp Sample
+		var ts int64
+		if err := rows.Scan(&ts, &smp.HasGrid, &smp.BatterySOC); err != nil {
+			return nil, fmt.Errorf("scan sample: %w", err)
+		}
+		smp.Time = time.Unix(ts, 0)
+		samples = append(samples, smp)
+	}
+	return samples, rows.Err()
+}
+
+// OutageSummary describes grid outages within a time window.
+type OutageSummary struct {
+	Count   int
+	Total   time.Duration
+	Longest time.Duration
+}
+
+// outagePeriod is a span of time without grid.
+type outagePeriod struct {
+	start, end time.Time
+}
+
+// outagePeriods returns the outages in [from, to] from chronologically
+// ordered events. An event before from sets the initial state; an outage
+// still ongoing at to ends at to.
+func outagePeriods(events []GridEvent, from, to time.Time) []outagePeriod {
+	var periods []outagePeriod
+	inOutage := false
+
+	for _, e := range events {
+		if inOutage == !e.HasGrid {
+			continue // not a transition
+		}
+		t := e.Time
+		if t.Before(from) {
+			t = from
+		}
+		if !e.HasGrid {
+			inOutage = true
+			periods = append(periods, outagePeriod{start: t})
+			continue
+		}
+		inOutage = false
+		periods[len(periods)-1].end = t
+	}
+	if inOutage {
+		periods[len(periods)-1].end = to
+	}
+	return periods
+}
+
+// summarizeOutages computes outages in [from, to] from chronologically
+// ordered events, as outagePeriods does.
+func summarizeOutages(events []GridEvent, from, to time.Time) OutageSummary {
+	var sum OutageSummary
+	for _, p := range outagePeriods(events, from, to) {
+		sum.Count++
+		sum.addOutage(p.end.Sub(p.start))
+	}
+	return sum
+}
+
+// outagesByHour adds up the time without grid in each hour of the day
+// (local time), splitting outages at hour boundaries.
+func outagesByHour(periods []outagePeriod) [24]time.Duration {
+	var byHour [24]time.Duration
+	for _, p := range periods {
+		for t := p.start.Local(); t.Before(p.end); {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			if next.After(p.end) {
+				next = p.end
+			}
+			byHour[t.Hour()] += next.Sub(t)
+			t = next
+		}
+	}
+	return byHour
+}
+
+func (s *OutageSummary) addOutage(d time.Duration) {
+	s.Total += d
+	if d > s.Longest {
+		s.Longest = d
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// runDailySummary broadcasts yesterday's energy report every day at
+// cfg.DailySummaryAt until ctx is cancelled.
+func runDailySummary(ctx context.Context, app *App) {
+	at := *app.cfg.DailySummaryAt
+	for {
+		next := nextClockTime(time.Now(), at)
+		slog.Info("[summary] Next daily summary scheduled", "at", next.Format("2006-01-02 15:04"))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		app.notifiers.Broadcast(AlertInfo, app.buildDailySummary(ctx, next.AddDate(0, 0, -1)))
+	}
+}
+
+// nextClockTime returns the first moment after now at the given offset from
+// local midnight.
+func nextClockTime(now time.Time, at time.Duration) time.Time {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	next := midnight.Add(at)
+	if !next.After(now) {
+		next = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()).Add(at)
+	}
+	return next
+}
+
+// buildDailySummary reports energy totals from Deye and grid hours from the
+// local history for the given day. The data is fetched once and rendered
+// in each language asked for.
+func (a *App) buildDailySummary(ctx context.Context, day time.Time) Localized {
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	to := from.AddDate(0, 0, 1)
+
+	var parts []Localized
+	for _, st := range a.cfg.Stations {
+		energy, err := a.deye.GetStationEnergyDaily(ctx, st.ID, from)
+		if err != nil {
+			slog.Error("[summary] Failed to get daily energy", "station", st.name(), "err", err)
+		}
+
+		var outages *OutageSummary
+		events, err := a.store.GridEventsSince(st, from)
+		if err != nil {
+			slog.Error("[summary] Failed to load grid events", "station", st.name(), "err", err)
+		} else if len(events) > 0 {
+			sum := summarizeOutages(eventsBefore(events, to), from, to)
+			outages = &sum
+		}
+
+		parts = append(parts, withStationLabels(st, func(l Lang) string {
+			return formatDailySummary(l, energy, outages, to.Sub(from), a.cfg.DeyeBatteryCapacityWh)
+		}))
+	}
+
+	return func(l Lang) string {
+		texts := make([]string, len(parts))
+		for i, part := range parts {
+			texts[i] = part(l)
+		}
+		return l.tr("summary.title", from.Format("02.01.2006")) + strings.Join(texts, "\n\n")
+	}
+}
+
+// runMonthlyReport broadcasts the previous month's energy report on the 1st
+// of every month at cfg.MonthlyReportAt until ctx is cancelled.
+func runMonthlyReport(ctx context.Context, app *App) {
+	at := *app.cfg.MonthlyReportAt
+	for {
+		next := nextMonthStart(time.Now(), at)
+		slog.Info("[summary] Next monthly report scheduled", "at", next.Format("2006-01-02 15:04"))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		app.notifiers.Broadcast(AlertInfo, app.buildMonthlyReport(ctx, next.AddDate(0, -1, 0)))
+	}
+}
+
+// nextMonthStart returns the first moment after now that is the 1st of a
+// month at the given offset from midnight.
+func nextMonthStart(now time.Time, at time.Duration) time.Time {
+	next := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Add(at)
+	if !next.After(now) {
+		next = time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location()).Add(at)
+	}
+	return next
+}
+
+// buildMonthlyReport reports the energy totals of the month containing
+// month, compared with the month before it.
+func (a *App) buildMonthlyReport(ctx context.Context, month time.Time) Localized {
+	var parts []Localized
+	for _, st := range a.cfg.Stations {
+		cur, err := a.deye.GetStationEnergyMonthly(ctx, st.ID, month)
+		if err != nil {
+			slog.Error("[summary] Failed to get monthly energy", "station", st.name(), "err", err)
+		}
+		// Missing on the first months after installation; the report is
+		// sent without the comparison then
+		prev, err := a.deye.GetStationEnergyMonthly(ctx, st.ID, month.AddDate(0, -1, 0))
+		if err != nil {
+			slog.Warn("[summary] No energy data for the previous month", "station", st.name(), "err", err)
+		}
+
+		parts = append(parts, withStationLabels(st, func(l Lang) string {
+			return formatMonthlyReport(l, cur, prev)
+		}))
+	}
+
+	return func(l Lang) string {
+		texts := make([]string, len(parts))
+		for i, part := range parts {
+			texts[i] = part(l)
+		}
+		return l.tr("monthly.title", month.Format("01.2006")) + strings.Join(texts, "\n\n")
+	}
+}
+
+// formatMonthlyReport renders one station's monthly totals. cur or prev may
+// be nil when unavailable.
+func formatMonthlyReport(l Lang, cur, prev *StationHistoryItem) string {
+	if cur == nil {
+		return strings.TrimSuffix(l.tr("summary.no_energy"), "\n")
+	}
+
+	change := func(get func(*StationHistoryItem) float64) string {
+		if prev == nil || get(prev) == 0 {
+			return ""
+		}
+		return l.tr("monthly.change", (get(cur)-get(prev))/get(prev)*100)
+	}
+
+	var b strings.Builder
+	b.WriteString(l.tr("monthly.generation", cur.GenerationValue,
+		change(func(i *StationHistoryItem) float64 { return i.GenerationValue })))
+	b.WriteString(l.tr("monthly.consumption", cur.ConsumptionValue,
+		change(func(i *StationHistoryItem) float64 { return i.ConsumptionValue })))
+	b.WriteString(l.tr("monthly.purchase", cur.PurchaseValue,
+		change(func(i *StationHistoryItem) float64 { return i.PurchaseValue })))
+	// Share of the generated energy used on site rather than fed to the grid
+	if cur.GenerationValue > 0 {
+		ratio := max(0, cur.GenerationValue-cur.GridValue) / cur.GenerationValue * 100
+		b.WriteString(l.tr("monthly.self_consumption", ratio))
+	}
+	if prev == nil {
+		b.WriteString(l.tr("monthly.no_previous"))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// eventsBefore drops events at or after t.
+func eventsBefore(events []GridEvent, t time.Time) []GridEvent {
+	for i, e := range events {
+		if !e.Time.Before(t) {
+			return events[:i]
+		}
+	}
+	return events
+}
+
+// formatDailySummary renders one station's report. energy or outages may be
+// nil when unavailable.
+func formatDailySummary(l Lang, energy *StationHistoryItem, outages *OutageSummary, period time.Duration, capacityWh float64) string {
+	var b strings.Builder
+	if energy != nil {
+		b.WriteString(l.tr("summary.generation", energy.GenerationValue))
+		b.WriteString(l.tr("summary.consumption", energy.ConsumptionValue))
+		b.WriteString(l.tr("summary.purchase", energy.PurchaseValue))
+		if capacityWh > 0 {
+			cycles := energy.DischargeValue * 1000 / capacityWh
+			b.WriteString(l.tr("summary.cycles", cycles))
+		}
+	} else {
+		b.WriteString(l.tr("summary.no_energy"))
+	}
+
+	if outages != nil {
+		b.WriteString(l.tr("summary.grid_up", formatDuration(l, period-outages.Total)))
+		if outages.Count > 0 {
+			b.WriteString(l.tr("summary.outages", outages.Count, formatDuration(l, outages.Total)))
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -2,28 +2,58 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 type TelegramBot struct {
 	token      string
 	userIDs    []int64
+	adminIDs   []int64
 	httpClient *http.Client
-	offset     int64
+	// uploadClient has a longer timeout than httpClient so large files
+	// aren't cut off mid-upload
+	uploadClient *http.Client
+	offset       int64
+	settings     *SettingsStore
+	limiter      *rateLimiter
+	threadID     int64 // forum topic for messages to group chats; 0 = general
+	dryRun       bool  // log outgoing messages instead of sending them
+
+	mu             sync.Mutex
+	username       string          // the bot's own username, from GetMe
+	lastMessageIDs map[int64]int64 // chatID → last status message ID
 }
 
-func NewTelegramBot(token string, userIDs []int64) *TelegramBot {
+func NewTelegramBot(cfg *Config, settings *SettingsStore) *TelegramBot {
 	return &TelegramBot{
-		token:   token,
-		userIDs: userIDs,
-		httpClient: &http.Client{
-			Timeout: 60 * time.Second,
-		},
+		token:          cfg.TelegramBotToken,
+		userIDs:        cfg.TelegramUserIDs,
+		adminIDs:       cfg.AdminUserIDs,
+		settings:       settings,
+		limiter:        newRateLimiter(cfg.TelegramRateLimit),
+		threadID:       cfg.TelegramThreadID,
+		dryRun:         cfg.DryRun,
+		httpClient:     newHTTPClient(60*time.Second, cfg.ProxyURL),
+		uploadClient:   newHTTPClient(5*time.Minute, cfg.ProxyURL),
+		lastMessageIDs: make(map[int64]int64),
 	}
 }
 
@@ -34,75 +64,665 @@ func (b *TelegramBot) apiURL(method string) string {
 // --- Send Message ---
 
 type sendMessageRequest struct {
-	ChatID    int64  `json:"chat_id"`
-	Text      string `json:"text"`
-	ParseMode string `json:"parse_mode"`
+	ChatID          int64                 `json:"chat_id"`
+	MessageThreadID int64                 `json:"message_thread_id,omitempty"`
+	Text            string                `json:"text"`
+	ParseMode       string                `json:"parse_mode"`
+	ReplyMarkup     *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
 }
 
 type telegramResponse struct {
 	OK          bool            `json:"ok"`
 	Description string          `json:"description"`
 	Result      json.RawMessage `json:"result"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"` // seconds, set on HTTP 429
+	} `json:"parameters"`
 }
 
-func (b *TelegramBot) SendMessage(chatID int64, text string) error {
-	body := sendMessageRequest{
-		ChatID:    chatID,
-		Text:      text,
-		ParseMode: "HTML",
-	}
+// apiError is an unsuccessful Bot API response.
+type apiError struct {
+	Method      string
+	StatusCode  int
+	Description string
+	RetryAfter  time.Duration // how long Telegram asks to wait on 429
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("telegram %s failed (HTTP %d): %s", e.Method, e.StatusCode, e.Description)
+}
 
+// callAPI POSTs a JSON body to a Bot API method and returns the raw result.
+func (b *TelegramBot) callAPI(method string, body interface{}) (json.RawMessage, error) {
 	data, err := json.Marshal(body)
 	if err != nil {
-		return fmt.Errorf("marshal sendMessage: %w", err)
+		return nil, fmt.Errorf("marshal %s: %w", method, err)
 	}
+	return b.post(context.Background(), b.httpClient, method, "application/json", data)
+}
 
-	resp, err := b.httpClient.Post(b.apiURL("sendMessage"), "application/json", bytes.NewReader(data))
+// telegramAttempts is how many times a Bot API request is tried before its
+// error is returned.
+const telegramAttempts = 3
+
+// telegramRetryBase is the wait before the first retry; it doubles after
+// every attempt.
+const telegramRetryBase = time.Second
+
+// post sends a request body to a Bot API method, through the rate limiter
+// and with retries.
+func (b *TelegramBot) post(ctx context.Context, client *http.Client, method, contentType string, data []byte) (json.RawMessage, error) {
+	return withTelegramRetry(ctx, method, func() (json.RawMessage, error) {
+		b.limiter.Wait()
+		return b.do(ctx, client, method, contentType, data)
+	})
+}
+
+// do makes a single request to a Bot API method.
+func (b *TelegramBot) do(ctx context.Context, client *http.Client, method, contentType string, data []byte) (json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", b.apiURL(method), bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("create %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("sendMessage request: %w", err)
+		return nil, fmt.Errorf("%s request: %w", method, err)
 	}
 	defer resp.Body.Close()
+	return parseAPIResponse(method, resp)
+}
+
+// withTelegramRetry calls do up to telegramAttempts times. Network errors,
+// 5xx and 429 are retried with exponential backoff and jitter, or after the
+// retry_after Telegram asks for; other API errors such as 400 or 403 are
+// returned at once.
+func withTelegramRetry(ctx context.Context, method string, do func() (json.RawMessage, error)) (json.RawMessage, error) {
+	wait := telegramRetryBase
+	for attempt := 1; ; attempt++ {
+		result, err := do()
+		if err == nil || attempt == telegramAttempts || ctx.Err() != nil || !retryableTelegramError(err) {
+			return result, err
+		}
 
+		delay := withJitter(wait, 20)
+		var apiErr *apiError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			delay = apiErr.RetryAfter
+		}
+		slog.Warn("[telegram] Request failed, retrying", "method", method, "after", delay, "attempt", attempt, "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+		wait *= 2
+	}
+}
+
+// retryableTelegramError reports whether a failed request may succeed when
+// repeated: anything but an API error, or a 429 or 5xx one.
+func retryableTelegramError(err error) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500
+}
+
+// upload POSTs a multipart/form-data request with a single file to a Bot API method.
+func (b *TelegramBot) upload(method string, fields map[string]string, fileField, filename string, file io.Reader) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			return nil, fmt.Errorf("write %s field %s: %w", method, k, err)
+		}
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		quoteEscaper.Replace(fileField), quoteEscaper.Replace(filename)))
+	h.Set("Content-Type", uploadContentType(filename))
+	fw, err := mw.CreatePart(h)
+	if err != nil {
+		return nil, fmt.Errorf("create %s file part: %w", method, err)
+	}
+	if _, err := io.Copy(fw, file); err != nil {
+		return nil, fmt.Errorf("write %s file: %w", method, err)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("close %s form: %w", method, err)
+	}
+
+	return b.post(context.Background(), b.uploadClient, method, mw.FormDataContentType(), buf.Bytes())
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// uploadContentType guesses the MIME type of an uploaded file from its
+// name, so Telegram shows e.g. CSV exports as spreadsheets.
+func uploadContentType(filename string) string {
+	ext := strings.ToLower(path.Ext(filename))
+	if ext == ".csv" {
+		// Missing from Go's built-in table and from some mime.types files
+		return "text/csv; charset=utf-8"
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func parseAPIResponse(method string, resp *http.Response) (json.RawMessage, error) {
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("read sendMessage response: %w", err)
+		return nil, fmt.Errorf("read %s response: %w", method, err)
 	}
 
 	var tgResp telegramResponse
 	if err := json.Unmarshal(respBody, &tgResp); err != nil {
-		return fmt.Errorf("unmarshal sendMessage response: %w", err)
+		if resp.StatusCode >= 500 {
+			// A proxy or load balancer error page rather than the Bot API
+			return nil, &apiError{Method: method, StatusCode: resp.StatusCode, Description: http.StatusText(resp.StatusCode)}
+		}
+		return nil, fmt.Errorf("unmarshal %s response: %w", method, err)
 	}
 
 	if !tgResp.OK {
-		return fmt.Errorf("telegram sendMessage failed: %s", tgResp.Description)
+		return nil, &apiError{
+			Method:      method,
+			StatusCode:  resp.StatusCode,
+			Description: tgResp.Description,
+			RetryAfter:  time.Duration(tgResp.Parameters.RetryAfter) * time.Second,
+		}
+	}
+
+	return tgResp.Result, nil
+}
+
+// telegramMaxMessageLen is the Bot API limit on message text, in characters.
+const telegramMaxMessageLen = 4096
+
+// splitHTMLMessage cuts an HTML message into pieces of at most limit
+// characters, breaking between lines where it can. A line longer than limit
+// is cut between characters, never inside a tag or an entity such as &amp;.
+// Tags left open at the end of a piece are closed there and reopened at the
+// start of the next one, and that room is kept in every piece.
+func splitHTMLMessage(text string, limit int) []string {
+	var chunks []string
+	var open []string // opening tags in effect, outermost first
+	var cur strings.Builder
+	curLen := 0      // characters in cur, including the reopened tags
+	hasText := false // cur has more than tags
+	newPiece := func() {
+		prefix := strings.Join(open, "")
+		cur.Reset()
+		cur.WriteString(prefix)
+		curLen = utf8.RuneCountInString(prefix)
+		hasText = false
+	}
+	flush := func() {
+		if hasText {
+			chunks = append(chunks, cur.String()+htmlClosers(open))
+		}
+		newPiece()
+	}
+	add := func(atom string) {
+		if strings.HasPrefix(atom, "<") && len(atom) > 1 {
+			open = applyHTMLTag(open, atom)
+		} else {
+			hasText = true
+		}
+		cur.WriteString(atom)
+		curLen += utf8.RuneCountInString(atom)
+	}
+	// fits reports whether atoms can be added to the current piece
+	fits := func(atoms []string) bool {
+		n, tags := curLen, open
+		for _, a := range atoms {
+			n += utf8.RuneCountInString(a)
+			if strings.HasPrefix(a, "<") && len(a) > 1 {
+				tags = applyHTMLTag(tags, a)
+			}
+		}
+		return n+len(htmlClosers(tags)) <= limit
 	}
 
+	for _, line := range strings.Split(text, "\n") {
+		atoms := htmlAtoms(line)
+		if hasText {
+			atoms = append([]string{"\n"}, atoms...)
+		}
+		if !fits(atoms) && hasText {
+			flush()
+			atoms = htmlAtoms(line)
+		}
+		if fits(atoms) {
+			for _, a := range atoms {
+				add(a)
+			}
+			continue
+		}
+		for _, a := range atoms {
+			if !fits([]string{a}) && hasText {
+				flush()
+			}
+			add(a)
+		}
+	}
+	flush()
+	return chunks
+}
+
+// htmlEntityRe matches a character reference such as &amp; or &#39;.
+var htmlEntityRe = regexp.MustCompile(`^&#?[0-9A-Za-z]{1,10};`)
+
+// htmlAtoms splits HTML text into tags, entities and single characters,
+// which a split must not cut through.
+func htmlAtoms(s string) []string {
+	var atoms []string
+	for len(s) > 0 {
+		n := 0
+		switch s[0] {
+		case '<':
+			n = strings.IndexByte(s, '>') + 1
+		case '&':
+			n = len(htmlEntityRe.FindString(s))
+		}
+		if n <= 0 {
+			_, n = utf8.DecodeRuneInString(s)
+		}
+		atoms = append(atoms, s[:n])
+		s = s[n:]
+	}
+	return atoms
+}
+
+// applyHTMLTag returns the open tags after tag: pushed if it opens an
+// element, with the innermost matching one removed if it closes one. open
+// is not modified.
+func applyHTMLTag(open []string, tag string) []string {
+	name, closing := htmlTagName(tag)
+	if !closing {
+		return append(slices.Clip(open), tag)
+	}
+	for j := len(open) - 1; j >= 0; j-- {
+		if n, _ := htmlTagName(open[j]); n == name {
+			return slices.Delete(slices.Clone(open), j, j+1)
+		}
+	}
+	return open
+}
+
+// htmlClosers returns the closing tags for open, innermost first.
+func htmlClosers(open []string) string {
+	var b strings.Builder
+	for j := len(open) - 1; j >= 0; j-- {
+		name, _ := htmlTagName(open[j])
+		b.WriteString("</" + name + ">")
+	}
+	return b.String()
+}
+
+// htmlTagName returns the lowercase element name of a tag such as
+// <a href="..."> or </b>.
+func htmlTagName(tag string) (name string, closing bool) {
+	s := strings.TrimSuffix(strings.TrimPrefix(tag, "<"), ">")
+	if closing = strings.HasPrefix(s, "/"); closing {
+		s = s[1:]
+	}
+	if i := strings.IndexAny(s, " \t\n"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.ToLower(s), closing
+}
+
+// SendMessage sends an HTML message, splitting it into several if it is
+// longer than Telegram allows. It stops at the first piece that fails.
+func (b *TelegramBot) SendMessage(chatID int64, text string) error {
+	for _, chunk := range splitHTMLMessage(text, telegramMaxMessageLen) {
+		if _, err := b.sendMessage(chatID, chunk, nil); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (b *TelegramBot) Broadcast(text string) {
-	for _, userID := range b.userIDs {
-		if err := b.SendMessage(userID, text); err != nil {
-			log.Printf("[telegram] failed to send to %d: %v", userID, err)
+// sendMessage sends a message with an optional inline keyboard and returns its message ID.
+func (b *TelegramBot) sendMessage(chatID int64, text string, markup *InlineKeyboardMarkup) (int64, error) {
+	if b.dryRun {
+		slog.Info("[telegram] Dry run, not sending message", "chat", chatID, "text", text)
+		return 0, nil
+	}
+	result, err := b.callAPI("sendMessage", sendMessageRequest{
+		ChatID:          chatID,
+		MessageThreadID: b.threadFor(chatID),
+		Text:            text,
+		ParseMode:       "HTML",
+		ReplyMarkup:     markup,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var msg Message
+	if err := json.Unmarshal(result, &msg); err != nil {
+		return 0, fmt.Errorf("unmarshal sent message: %w", err)
+	}
+	return msg.MessageID, nil
+}
+
+// Broadcast sends msg to every user whose notification preference accepts
+// kind, in the user's language. Chats that ignore quiet hours already got
+// the alerts a quiet hours summary repeats.
+func (b *TelegramBot) Broadcast(kind AlertKind, msg Localized) {
+	b.broadcast(kind, msg, func(cs ChatSettings) bool {
+		return kind != AlertQuietSummary || !cs.IgnoreQuietHours
+	})
+}
+
+// BroadcastDuringQuietHours sends an alert held back by quiet hours to the
+// chats that opted out of them.
+func (b *TelegramBot) BroadcastDuringQuietHours(kind AlertKind, msg Localized) {
+	b.broadcast(kind, msg, func(cs ChatSettings) bool { return cs.IgnoreQuietHours })
+}
+
+func (b *TelegramBot) broadcast(kind AlertKind, msg Localized, want func(ChatSettings) bool) {
+	msg = msg.cached()
+	b.sendToAll(b.alertRecipients(kind, want), func(chatID int64) error {
+		return b.SendMessage(chatID, msg(b.LangFor(chatID)))
+	})
+}
+
+// alertRecipients are the unmuted recipients whose notification preference
+// accepts kind and whose settings pass want.
+func (b *TelegramBot) alertRecipients(kind AlertKind, want func(ChatSettings) bool) []int64 {
+	var chatIDs []int64
+	for _, userID := range b.unmutedRecipients() {
+		if cs := b.chatSettings(userID); cs.Notify.Wants(kind) && want(cs) {
+			chatIDs = append(chatIDs, userID)
+		}
+	}
+	return chatIDs
+}
+
+// BroadcastStatus is like Broadcast for an AlertInfo message but attaches
+// markup and remembers the sent messages so a later /status can refresh
+// them in place.
+func (b *TelegramBot) BroadcastStatus(msg Localized, markup func(Lang) *InlineKeyboardMarkup) {
+	msg = msg.cached()
+	recipients := b.alertRecipients(AlertInfo, func(ChatSettings) bool { return true })
+	b.sendToAll(recipients, func(chatID int64) error {
+		l := b.LangFor(chatID)
+		id, err := b.sendMessage(chatID, msg(l), markup(l))
+		if err != nil {
+			return err
+		}
+		b.setLastMessageID(chatID, id)
+		return nil
+	})
+}
+
+// broadcastWorkers is how many chats a broadcast sends to at once; the
+// rate limiter still paces the requests themselves.
+const broadcastWorkers = 10
+
+// sendToAll runs send for every chat, broadcastWorkers at a time, so one
+// slow chat doesn't hold up the rest. Failures are logged per chat.
+func (b *TelegramBot) sendToAll(chatIDs []int64, send func(chatID int64) error) {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed = make(map[int64]error)
+	)
+	sem := make(chan struct{}, broadcastWorkers)
+	for _, chatID := range chatIDs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := send(chatID); err != nil {
+				mu.Lock()
+				failed[chatID] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for chatID, err := range failed {
+		if isBlockedError(err) {
+			b.dropBlockedChat(chatID)
+			continue
 		}
+		slog.Error("[telegram] Failed to send", "chat", chatID, "err", err)
+	}
+	if len(failed) > 0 {
+		slog.Warn("[telegram] Broadcast incomplete", "failed", len(failed), "chats", len(chatIDs))
 	}
 }
 
+// blockedDescriptions are the Telegram error descriptions that mean a chat
+// will never accept messages again: the user blocked the bot or deleted
+// their account, or the bot was removed from the group.
+var blockedDescriptions = []string{
+	"bot was blocked by the user",
+	"user is deactivated",
+	"bot was kicked from the",
+	"bot is not a member of the",
+	"chat not found",
+}
+
+// isBlockedError reports whether err is Telegram refusing a message because
+// the chat is gone for good. "chat not found" comes with 400 rather than
+// 403.
+func isBlockedError(err error) bool {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden && apiErr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	desc := strings.ToLower(apiErr.Description)
+	if apiErr.StatusCode == http.StatusBadRequest {
+		return strings.Contains(desc, "chat not found")
+	}
+	return slices.ContainsFunc(blockedDescriptions, func(s string) bool { return strings.Contains(desc, s) })
+}
+
+// dropBlockedChat stops broadcasting to a chat that blocked the bot. An
+// approved subscriber loses its subscription; a TELEGRAM_USER_IDS chat is
+// skipped until ClearBlocked.
+func (b *TelegramBot) dropBlockedChat(chatID int64) {
+	if b.settings == nil {
+		slog.Warn("[telegram] Chat blocked the bot", "chat", chatID)
+		return
+	}
+	wasSubscriber := b.settings.Get(chatID).Subscriber
+	err := b.settings.Update(chatID, func(cs *ChatSettings) {
+		cs.Subscriber = false
+		cs.Blocked = true
+	})
+	if err != nil {
+		slog.Error("[telegram] Failed to save blocked chat", "chat", chatID, "err", err)
+		return
+	}
+	slog.Warn("[telegram] Chat blocked the bot, removed from broadcasts", "chat", chatID, "unsubscribed", wasSubscriber)
+}
+
+// ClearBlocked resumes broadcasts to a chat flagged by dropBlockedChat,
+// once it writes to the bot again.
+func (b *TelegramBot) ClearBlocked(chatID int64) {
+	if b.settings == nil || !b.settings.Get(chatID).Blocked {
+		return
+	}
+	if err := b.settings.Update(chatID, func(cs *ChatSettings) { cs.Blocked = false }); err != nil {
+		slog.Error("[telegram] Failed to save unblocked chat", "chat", chatID, "err", err)
+		return
+	}
+	slog.Info("[telegram] Chat unblocked the bot, resuming broadcasts", "chat", chatID)
+}
+
+// threadFor returns the topic messages to chatID go to. Only group chats
+// (negative IDs) have topics.
+func (b *TelegramBot) threadFor(chatID int64) int64 {
+	if chatID < 0 {
+		return b.threadID
+	}
+	return 0
+}
+
+// --- Send Photo ---
+
+// SendPhoto uploads an image with an HTML caption.
+func (b *TelegramBot) SendPhoto(chatID int64, image io.Reader, filename, caption string) error {
+	if b.dryRun {
+		slog.Info("[telegram] Dry run, not sending photo", "chat", chatID, "file", filename, "caption", caption)
+		return nil
+	}
+	fields := map[string]string{
+		"chat_id":    strconv.FormatInt(chatID, 10),
+		"caption":    caption,
+		"parse_mode": "HTML",
+	}
+	if thread := b.threadFor(chatID); thread != 0 {
+		fields["message_thread_id"] = strconv.FormatInt(thread, 10)
+	}
+	if _, err := b.upload("sendPhoto", fields, "photo", filename, image); err != nil {
+		return fmt.Errorf("upload photo %s: %w", filename, err)
+	}
+	return nil
+}
+
+// SendDocument uploads a file such as a CSV export to the chat. The
+// filename is shown to the user and determines the content type.
+func (b *TelegramBot) SendDocument(chatID int64, data io.Reader, filename, caption string) error {
+	if b.dryRun {
+		slog.Info("[telegram] Dry run, not sending document", "chat", chatID, "file", filename, "caption", caption)
+		return nil
+	}
+	fields := map[string]string{
+		"chat_id":    strconv.FormatInt(chatID, 10),
+		"caption":    caption,
+		"parse_mode": "HTML",
+	}
+	if thread := b.threadFor(chatID); thread != 0 {
+		fields["message_thread_id"] = strconv.FormatInt(thread, 10)
+	}
+	if _, err := b.upload("sendDocument", fields, "document", filename, data); err != nil {
+		return fmt.Errorf("upload document %s: %w", filename, err)
+	}
+	return nil
+}
+
+// --- Edit Message ---
+
+type editMessageTextRequest struct {
+	ChatID      int64                 `json:"chat_id"`
+	MessageID   int64                 `json:"message_id"`
+	Text        string                `json:"text"`
+	ParseMode   string                `json:"parse_mode"`
+	ReplyMarkup *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+}
+
+func (b *TelegramBot) EditMessage(chatID, messageID int64, text string) error {
+	return b.EditMessageWithMarkup(chatID, messageID, text, nil)
+}
+
+// EditMessageWithMarkup replaces the text and inline keyboard of a message.
+// A nil markup removes the keyboard.
+func (b *TelegramBot) EditMessageWithMarkup(chatID, messageID int64, text string, markup *InlineKeyboardMarkup) error {
+	if b.dryRun {
+		slog.Info("[telegram] Dry run, not editing message", "chat", chatID, "message", messageID, "text", text)
+		return nil
+	}
+	_, err := b.callAPI("editMessageText", editMessageTextRequest{
+		ChatID:      chatID,
+		MessageID:   messageID,
+		Text:        text,
+		ParseMode:   "HTML",
+		ReplyMarkup: markup,
+	})
+	// Telegram rejects edits that don't change anything; the message already shows the text.
+	if err != nil && strings.Contains(err.Error(), "message is not modified") {
+		return nil
+	}
+	return err
+}
+
+// SendStatus refreshes the last status message in the chat in place, or
+// sends a new one if there is none or it can no longer be edited.
+func (b *TelegramBot) SendStatus(chatID int64, text string, markup *InlineKeyboardMarkup) error {
+	if id, ok := b.lastMessageID(chatID); ok {
+		err := b.EditMessageWithMarkup(chatID, id, text, markup)
+		if err == nil {
+			return nil
+		}
+		slog.Warn("[telegram] Failed to edit message, sending new", "message", id, "chat", chatID, "err", err)
+	}
+
+	id, err := b.sendMessage(chatID, text, markup)
+	if err != nil {
+		return err
+	}
+	b.setLastMessageID(chatID, id)
+	return nil
+}
+
+func (b *TelegramBot) lastMessageID(chatID int64) (int64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id, ok := b.lastMessageIDs[chatID]
+	return id, ok
+}
+
+func (b *TelegramBot) setLastMessageID(chatID, messageID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastMessageIDs[chatID] = messageID
+}
+
 // --- Get Updates (long polling) ---
 
 type Update struct {
-	UpdateID int64    `json:"update_id"`
-	Message  *Message `json:"message"`
+	UpdateID      int64          `json:"update_id"`
+	Message       *Message       `json:"message"`
+	CallbackQuery *CallbackQuery `json:"callback_query"`
+}
+
+// CallbackQuery is sent when a user presses an inline keyboard button.
+type CallbackQuery struct {
+	ID      string   `json:"id"`
+	From    User     `json:"from"`
+	Message *Message `json:"message"`
+	Data    string   `json:"data"`
+}
+
+type User struct {
+	ID        int64  `json:"id"`
+	FirstName string `json:"first_name"`
+	Username  string `json:"username"`
 }
 
 type Message struct {
 	MessageID int64  `json:"message_id"`
+	From      *User  `json:"from"`
 	Chat      Chat   `json:"chat"`
 	Text      string `json:"text"`
 }
 
 type Chat struct {
-	ID int64 `json:"id"`
+	ID   int64  `json:"id"` // negative for groups
+	Type string `json:"type"`
 }
 
 type getUpdatesRequest struct {
@@ -110,12 +730,9 @@ type getUpdatesRequest struct {
 	Timeout int   `json:"timeout"`
 }
 
-type getUpdatesResponse struct {
-	OK     bool     `json:"ok"`
-	Result []Update `json:"result"`
-}
-
-func (b *TelegramBot) GetUpdates() ([]Update, error) {
+// GetUpdates long-polls for new updates. Cancelling ctx aborts the
+// in-flight request.
+func (b *TelegramBot) GetUpdates(ctx context.Context) ([]Update, error) {
 	body := getUpdatesRequest{
 		Offset:  b.offset,
 		Timeout: 30,
@@ -126,38 +743,222 @@ func (b *TelegramBot) GetUpdates() ([]Update, error) {
 		return nil, fmt.Errorf("marshal getUpdates: %w", err)
 	}
 
-	resp, err := b.httpClient.Post(b.apiURL("getUpdates"), "application/json", bytes.NewReader(data))
+	// Not rate limited: long polling sends nothing to users
+	result, err := withTelegramRetry(ctx, "getUpdates", func() (json.RawMessage, error) {
+		return b.do(ctx, b.httpClient, "getUpdates", "application/json", data)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("getUpdates request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("read getUpdates response: %w", err)
+	var updates []Update
+	if err := json.Unmarshal(result, &updates); err != nil {
+		return nil, fmt.Errorf("unmarshal getUpdates result: %w", err)
 	}
 
-	var updResp getUpdatesResponse
-	if err := json.Unmarshal(respBody, &updResp); err != nil {
-		return nil, fmt.Errorf("unmarshal getUpdates response: %w", err)
+	if len(updates) > 0 {
+		b.offset = updates[len(updates)-1].UpdateID + 1
 	}
 
-	if !updResp.OK {
-		return nil, fmt.Errorf("getUpdates failed")
+	return updates, nil
+}
+
+// BotUser is the bot's own account as returned by getMe.
+type BotUser struct {
+	User
+	// CanReadAllGroupMessages is false in privacy mode, where groups only
+	// pass the bot commands and replies
+	CanReadAllGroupMessages bool `json:"can_read_all_group_messages"`
+}
+
+// GetMe fetches the bot's own account and remembers its username, which
+// group chats append to commands.
+func (b *TelegramBot) GetMe() (*BotUser, error) {
+	result, err := b.callAPI("getMe", struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	var me BotUser
+	if err := json.Unmarshal(result, &me); err != nil {
+		return nil, fmt.Errorf("unmarshal getMe result: %w", err)
 	}
 
-	if len(updResp.Result) > 0 {
-		b.offset = updResp.Result[len(updResp.Result)-1].UpdateID + 1
+	b.mu.Lock()
+	b.username = me.Username
+	b.mu.Unlock()
+	return &me, nil
+}
+
+// Username returns the bot's username, or "" before a successful GetMe.
+func (b *TelegramBot) Username() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.username
+}
+
+// --- Webhook ---
+
+type setWebhookRequest struct {
+	URL            string   `json:"url"`
+	SecretToken    string   `json:"secret_token"`
+	AllowedUpdates []string `json:"allowed_updates"`
+}
+
+// SetWebhook makes Telegram post updates to url, with secret in the
+// X-Telegram-Bot-Api-Secret-Token header. getUpdates stops working until
+// DeleteWebhook.
+func (b *TelegramBot) SetWebhook(url, secret string) error {
+	_, err := b.callAPI("setWebhook", setWebhookRequest{
+		URL:            url,
+		SecretToken:    secret,
+		AllowedUpdates: []string{"message", "callback_query"},
+	})
+	return err
+}
+
+// DeleteWebhook switches back to getUpdates. Pending updates are kept.
+func (b *TelegramBot) DeleteWebhook() error {
+	_, err := b.callAPI("deleteWebhook", struct{}{})
+	return err
+}
+
+// webhookHandler accepts the updates Telegram posts and queues them on
+// updates. Requests without the secret given to SetWebhook are rejected.
+func webhookHandler(secret string, updates chan<- Update) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var update Update
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&update); err != nil {
+			http.Error(w, "bad update", http.StatusBadRequest)
+			return
+		}
+		select {
+		case updates <- update:
+		case <-r.Context().Done():
+		}
+	})
+}
+
+type answerCallbackQueryRequest struct {
+	CallbackQueryID string `json:"callback_query_id"`
+	Text            string `json:"text,omitempty"`
+}
+
+// AnswerCallbackQuery stops the button's loading spinner, optionally
+// showing text as a toast.
+func (b *TelegramBot) AnswerCallbackQuery(id, text string) error {
+	_, err := b.callAPI("answerCallbackQuery", answerCallbackQueryRequest{
+		CallbackQueryID: id,
+		Text:            text,
+	})
+	return err
+}
+
+// rateLimiter is a token bucket holding up to one second's worth of
+// requests, so short bursts go out at once and sustained load is spread.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	return &rateLimiter{rate: perSecond, tokens: perSecond, last: time.Now()}
+}
+
+// Wait blocks until a request may be sent.
+func (l *rateLimiter) Wait() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	if l.tokens < 1 {
+		wait := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		time.Sleep(wait)
+		l.tokens = 1
+		l.last = time.Now()
 	}
+	l.tokens--
+}
+
+// IsAdmin reports whether chatID is listed in ADMIN_USER_IDS.
+func (b *TelegramBot) IsAdmin(chatID int64) bool {
+	return slices.Contains(b.adminIDs, chatID)
+}
 
-	return updResp.Result, nil
+// AdminIDs returns the chats listed in ADMIN_USER_IDS.
+func (b *TelegramBot) AdminIDs() []int64 {
+	return b.adminIDs
 }
 
+// IsListedUser reports whether chatID is listed in TELEGRAM_USER_IDS.
+func (b *TelegramBot) IsListedUser(chatID int64) bool {
+	return slices.Contains(b.userIDs, chatID)
+}
+
+// IsAllowedUser reports whether chatID may use the bot: users listed in
+// TELEGRAM_USER_IDS and approved subscribers.
 func (b *TelegramBot) IsAllowedUser(chatID int64) bool {
-	for _, id := range b.userIDs {
-		if id == chatID {
-			return true
+	if b.IsListedUser(chatID) {
+		return true
+	}
+	return b.settings != nil && b.settings.Get(chatID).Subscriber
+}
+
+// chatSettings returns the chat's settings, or the defaults without a store.
+func (b *TelegramBot) chatSettings(chatID int64) ChatSettings {
+	if b.settings == nil {
+		return ChatSettings{Notify: NotifyAll, Lang: activeLang}
+	}
+	return b.settings.Get(chatID)
+}
+
+// MutedUntil returns when /mute for the chat ends, or the zero time if its
+// alerts aren't muted.
+func (b *TelegramBot) MutedUntil(chatID int64) time.Time {
+	if b.settings == nil {
+		return time.Time{}
+	}
+	return b.settings.MutedUntil(chatID, time.Now())
+}
+
+// unmutedRecipients are the recipients whose alerts aren't muted.
+func (b *TelegramBot) unmutedRecipients() []int64 {
+	var ids []int64
+	for _, id := range b.recipients() {
+		if until := b.MutedUntil(id); !until.IsZero() {
+			slog.Debug("[telegram] Chat muted, skipping broadcast", "chat", id, "until", until.Format("15:04"))
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// LangFor returns the language of messages to chatID.
+func (b *TelegramBot) LangFor(chatID int64) Lang {
+	return b.chatSettings(chatID).Lang
+}
+
+// recipients are the chats broadcasts go to: TELEGRAM_USER_IDS plus
+// approved subscribers, except those that blocked the bot.
+func (b *TelegramBot) recipients() []int64 {
+	ids := slices.Clone(b.userIDs)
+	if b.settings == nil {
+		return ids
+	}
+	for _, id := range b.settings.Subscribers() {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
 		}
 	}
-	return false
+	return slices.DeleteFunc(ids, func(id int64) bool { return b.settings.Get(id).Blocked })
 }
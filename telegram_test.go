@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitHTMLMessage(t *testing.T) {
+	link := `<a href="https://example.com/outages/today">`
+	for _, tt := range []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{"short", "<b>Світло є</b>", 4096, []string{"<b>Світло є</b>"}},
+		{"between lines", "one\ntwo\nthree", 8, []string{"one\ntwo", "three"}},
+		{"long line", strings.Repeat("я", 25), 10, []string{strings.Repeat("я", 10), strings.Repeat("я", 10), strings.Repeat("я", 5)}},
+		{"nested tags", "<b><i>" + strings.Repeat("x", 10) + "</i></b>", 20, []string{
+			"<b><i>" + strings.Repeat("x", 6) + "</i></b>",
+			"<b><i>" + strings.Repeat("x", 4) + "</i></b>",
+		}},
+		{"link", link + "outage schedule</a> ok", 60, []string{
+			link + "outage sched</a>",
+			link + "ule</a> ok",
+		}},
+		{"entity at the boundary", "aaaaaaaa&amp;bbb", 10, []string{"aaaaaaaa", "&amp;bbb"}},
+		{"tag at the boundary", "aaaaaaa<b>bb</b>", 10, []string{"aaaaaaa", "<b>bb</b>"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitHTMLMessage(tt.text, tt.limit)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			for _, chunk := range got {
+				checkHTMLChunk(t, chunk, tt.limit)
+			}
+		})
+	}
+}
+
+var brokenMarkupRe = regexp.MustCompile(`<[^>]*(<|$)|&(#?[0-9A-Za-z]*)($|[^0-9A-Za-z;#])`)
+
+// checkHTMLChunk fails unless chunk fits limit, has balanced tags and cuts
+// no tag or entity.
+func checkHTMLChunk(t *testing.T, chunk string, limit int) {
+	t.Helper()
+	if n := utf8.RuneCountInString(chunk); n > limit {
+		t.Errorf("%q is %d characters, limit %d", chunk, n, limit)
+	}
+	if brokenMarkupRe.MatchString(chunk) {
+		t.Errorf("%q has a cut tag or entity", chunk)
+	}
+	var open []string
+	for _, tag := range htmlTagRe.FindAllString(chunk, -1) {
+		name, closing := htmlTagName(tag)
+		if !closing {
+			open = append(open, name)
+			continue
+		}
+		if len(open) == 0 || open[len(open)-1] != name {
+			t.Errorf("%q closes %s out of order", chunk, name)
+			return
+		}
+		open = open[:len(open)-1]
+	}
+	if len(open) > 0 {
+		t.Errorf("%q leaves %v open", chunk, open)
+	}
+}
+
+func TestIsBlockedError(t *testing.T) {
+	for _, tt := range []struct {
+		status int
+		desc   string
+		want   bool
+	}{
+		{http.StatusForbidden, "Forbidden: bot was blocked by the user", true},
+		{http.StatusForbidden, "Forbidden: user is deactivated", true},
+		{http.StatusForbidden, "Forbidden: bot was kicked from the group chat", true},
+		{http.StatusForbidden, "Forbidden: bot was kicked from the supergroup chat", true},
+		{http.StatusForbidden, "Forbidden: bot is not a member of the channel chat", true},
+		{http.StatusForbidden, "Forbidden: chat not found", true},
+		{http.StatusBadRequest, "Bad Request: chat not found", true},
+		{http.StatusBadRequest, "Bad Request: can't parse entities", false},
+		{http.StatusForbidden, "Forbidden: bot can't initiate conversation with a user", false},
+		{http.StatusTooManyRequests, "Too Many Requests: retry after 5", false},
+	} {
+		err := fmt.Errorf("send: %w", &apiError{Method: "sendMessage", StatusCode: tt.status, Description: tt.desc})
+		if got := isBlockedError(err); got != tt.want {
+			t.Errorf("isBlockedError(%d %q) = %v, want %v", tt.status, tt.desc, got, tt.want)
+		}
+	}
+	if isBlockedError(errors.New("connection reset")) {
+		t.Error("network error reported as blocked")
+	}
+}
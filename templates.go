@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// MessageData is what the status, poweron and poweroff templates render.
+// The embedded PowerStatus fields are available directly, e.g. {{.BatterySOC}}.
+type MessageData struct {
+	*PowerStatus
+	DtekLine     string // DTEK schedule line (HTML), empty if unavailable
+	Time         string // LastUpdateTime formatted for display
+	DeviceStatus string // online, alarm or offline, in the active language
+	GridQuality  string // grid voltage/frequency line, empty if unreported
+	Runtime      string // estimated battery runtime; set for poweroff only
+	Outage       string // how long the grid was off; poweron only, empty if unknown
+	AlarmLines   string // active inverter alarms, one per line; empty if none
+}
+
+func newMessageData(l Lang, s *PowerStatus, dtekLine string) MessageData {
+	deviceStatus := l.tr("device.offline")
+	switch s.DeviceState {
+	case deviceStateOnline:
+		deviceStatus = l.tr("device.online")
+	case deviceStateAlert:
+		deviceStatus = l.tr("device.alarm")
+	}
+	return MessageData{
+		PowerStatus:  s,
+		DtekLine:     dtekLine,
+		Time:         formatTime(s.LastUpdateTime),
+		DeviceStatus: deviceStatus,
+		GridQuality:  formatGridQualityLine(l, s),
+		AlarmLines:   formatAlarms(l, s.Alarms),
+	}
+}
+
+// templateFuncs are available in templates besides the text/template
+// builtins. deref reads optional values such as {{deref .GridVoltage}}.
+var templateFuncs = template.FuncMap{
+	"deref": ptrVal,
+}
+
+// Built-in templates. Output is Telegram HTML, so literal <, > and & in
+// custom templates must be escaped.
+const (
+	ukStatusTemplate = `<b>{{if .HasGrid}}⚡ Світло Є, але нема добра((({{else}}❌ Світла НЕМАЄ, але є добро{{end}}</b>
+
+{{if .HasGrid}}{{with .GridQuality}}{{.}}
+{{end}}{{end}}{{with .WirePower}}🔌 Лінія мережі: {{printf "%.0f" (deref .)}}W
+{{end}}☀️ Генерація: {{printf "%.0f" .GenerationPower}}W
+🏠 Споживання: {{printf "%.0f" .ConsumptionPower}}W
+🔋 Батарея: {{printf "%.0f" .BatterySOC}}% ({{printf "%.0f" .BatteryPower}}W){{with .BatteryTemp}} {{printf "%.0f" (deref .)}}°C{{end}}
+📡 Пристрій: {{.DeviceStatus}}
+{{with .AlarmLines}}{{.}}
+{{end}}{{with .DtekLine}}{{.}}
+{{end}}🕐 {{.Time}}`
+
+	ukPowerOnTemplate = `<b>⚡ Світло З'ЯВИЛОСЬ!</b>
+
+{{with .Outage}}⏱ Світла не було: {{.}}
+{{end}}🔌 Мережа: {{printf "%.0f" .GridPower}}W
+🔋 Батарея: {{printf "%.0f" .BatterySOC}}%
+☀️ Генерація: {{printf "%.0f" .GenerationPower}}W
+🏠 Споживання: {{printf "%.0f" .ConsumptionPower}}W
+{{with .DtekLine}}{{.}}
+{{end}}🕐 {{.Time}}`
+
+	ukPowerOffTemplate = `<b>❌ Світло ЗНИКЛО!</b>
+
+🔋 Батарея: {{printf "%.0f" .BatterySOC}}%
+⏳ Час роботи від батареї: {{.Runtime}}
+☀️ Генерація: {{printf "%.0f" .GenerationPower}}W
+🏠 Споживання: {{printf "%.0f" .ConsumptionPower}}W
+{{with .DtekLine}}{{.}}
+{{end}}🕐 {{.Time}}`
+
+	enStatusTemplate = `<b>{{if .HasGrid}}⚡ Power is ON{{else}}❌ Power is OFF{{end}}</b>
+
+{{if .HasGrid}}{{with .GridQuality}}{{.}}
+{{end}}{{end}}{{with .WirePower}}🔌 Grid line: {{printf "%.0f" (deref .)}}W
+{{end}}☀️ Generation: {{printf "%.0f" .GenerationPower}}W
+🏠 Consumption: {{printf "%.0f" .ConsumptionPower}}W
+🔋 Battery: {{printf "%.0f" .BatterySOC}}% ({{printf "%.0f" .BatteryPower}}W){{with .BatteryTemp}} {{printf "%.0f" (deref .)}}°C{{end}}
+📡 Device: {{.DeviceStatus}}
+{{with .AlarmLines}}{{.}}
+{{end}}{{with .DtekLine}}{{.}}
+{{end}}🕐 {{.Time}}`
+
+	enPowerOnTemplate = `<b>⚡ Power is BACK!</b>
+
+{{with .Outage}}⏱ Power was out for {{.}}
+{{end}}🔌 Grid: {{printf "%.0f" .GridPower}}W
+🔋 Battery: {{printf "%.0f" .BatterySOC}}%
+☀️ Generation: {{printf "%.0f" .GenerationPower}}W
+🏠 Consumption: {{printf "%.0f" .ConsumptionPower}}W
+{{with .DtekLine}}{{.}}
+{{end}}🕐 {{.Time}}`
+
+	enPowerOffTemplate = `<b>❌ Power is OUT!</b>
+
+🔋 Battery: {{printf "%.0f" .BatterySOC}}%
+⏳ Battery runtime: {{.Runtime}}
+☀️ Generation: {{printf "%.0f" .GenerationPower}}W
+🏠 Consumption: {{printf "%.0f" .ConsumptionPower}}W
+{{with .DtekLine}}{{.}}
+{{end}}🕐 {{.Time}}`
+)
+
+// Template names; a custom template is read from <name>.tmpl in TEMPLATES_DIR.
+const (
+	statusTemplate   = "status"
+	powerOnTemplate  = "poweron"
+	powerOffTemplate = "poweroff"
+)
+
+// defaultTemplates are the built-in templates by language.
+var defaultTemplates = map[Lang]map[string]string{
+	LangUK: {
+		statusTemplate:   ukStatusTemplate,
+		powerOnTemplate:  ukPowerOnTemplate,
+		powerOffTemplate: ukPowerOffTemplate,
+	},
+	LangEN: {
+		statusTemplate:   enStatusTemplate,
+		powerOnTemplate:  enPowerOnTemplate,
+		powerOffTemplate: enPowerOffTemplate,
+	},
+}
+
+// builtinTemplates are the parsed defaults by language.
+var builtinTemplates = mustParseTemplates()
+
+// messageTemplates are the templates in use: the built-in ones, replaced by
+// LoadTemplates at startup where custom ones exist.
+var messageTemplates = builtinTemplates
+
+func mustParseTemplates() map[Lang]map[string]*template.Template {
+	all := make(map[Lang]map[string]*template.Template, len(defaultTemplates))
+	for l, sources := range defaultTemplates {
+		tmpls, err := parseTemplates(l, sources)
+		if err != nil {
+			panic(err)
+		}
+		all[l] = tmpls
+	}
+	return all
+}
+
+// parseTemplates parses and trial-renders each template so mistakes such as
+// unknown fields are caught at startup rather than when an alert is due.
+func parseTemplates(l Lang, sources map[string]string) (map[string]*template.Template, error) {
+	sample := newMessageData(l, &PowerStatus{}, "")
+	tmpls := make(map[string]*template.Template, len(sources))
+	for name, src := range sources {
+		t, err := template.New(name).Funcs(templateFuncs).Parse(src)
+		if err != nil {
+			return nil, fmt.Errorf("parse template %s: %w", name, err)
+		}
+		if err := t.Execute(&strings.Builder{}, sample); err != nil {
+			return nil, fmt.Errorf("template %s: %w", name, err)
+		}
+		tmpls[name] = t
+	}
+	return tmpls, nil
+}
+
+// LoadTemplates replaces the built-in templates of activeLang with
+// <name>.tmpl files found in dir. Templates without a file keep their
+// default, and chats using another language keep the built-in ones.
+func LoadTemplates(dir string) error {
+	defaults := defaultTemplates[activeLang]
+	sources := make(map[string]string, len(defaults))
+	for name, def := range defaults {
+		sources[name] = def
+		path := filepath.Join(dir, name+".tmpl")
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		sources[name] = strings.TrimRight(string(data), "\n")
+		slog.Info("[templates] Using custom template", "name", name, "path", path)
+	}
+
+	tmpls, err := parseTemplates(activeLang, sources)
+	if err != nil {
+		return err
+	}
+	messageTemplates = maps.Clone(builtinTemplates)
+	messageTemplates[activeLang] = tmpls
+	return nil
+}
+
+// renderMessage executes the named template in l, falling back to the
+// built-in one if a custom template fails.
+func renderMessage(l Lang, name string, data MessageData) string {
+	var b strings.Builder
+	err := messageTemplates[l][name].Execute(&b, data)
+	if err == nil {
+		return b.String()
+	}
+	slog.Error("[templates] Failed to render, using default", "name", name, "err", err)
+
+	b.Reset()
+	if err := builtinTemplates[l][name].Execute(&b, data); err != nil {
+		slog.Error("[templates] Failed to render default", "name", name, "err", err)
+	}
+	return b.String()
+}